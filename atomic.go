@@ -0,0 +1,42 @@
+package dmap
+
+import "sync/atomic"
+
+// AtomicDMap holds a DMap that can be replaced as a whole, e.g. a config
+// cache that a background goroutine rebuilds and swaps in. Readers Load
+// the current map with a single atomic load and keep using it even if a
+// newer one is stored meanwhile, so they always see one complete map,
+// never a partially built one. The zero AtomicDMap holds no map.
+type AtomicDMap[K comparable, V any] struct {
+	p atomic.Pointer[DMap[K, V]]
+}
+
+// NewAtomicDMap returns an AtomicDMap holding m.
+func NewAtomicDMap[K comparable, V any](m DMap[K, V]) *AtomicDMap[K, V] {
+	a := &AtomicDMap[K, V]{}
+	a.Store(m)
+	return a
+}
+
+// Load returns the current map, or nil if none was stored.
+func (a *AtomicDMap[K, V]) Load() DMap[K, V] {
+	if p := a.p.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+// Store replaces the current map with m. m should be fully built before
+// it is stored; writes to it afterwards are visible to readers as usual.
+// The replaced map is not closed.
+func (a *AtomicDMap[K, V]) Store(m DMap[K, V]) {
+	a.p.Store(&m)
+}
+
+// Swap is like Store, but returns the replaced map, e.g. to Close it.
+func (a *AtomicDMap[K, V]) Swap(m DMap[K, V]) DMap[K, V] {
+	if p := a.p.Swap(&m); p != nil {
+		return *p
+	}
+	return nil
+}
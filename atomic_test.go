@@ -0,0 +1,64 @@
+package dmap
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAtomicDMap(t *testing.T) {
+	var empty AtomicDMap[string, int]
+	require.Nil(t, empty.Load())
+
+	build := func(gen int) DMap[string, int] {
+		m := New[string, int](4)
+		for i := 0; i < 100; i++ {
+			m.Set(fmt.Sprintf("key_%d", i), gen)
+		}
+		return m
+	}
+	a := NewAtomicDMap(build(0))
+
+	var stop int32
+	var reads int64
+	wg := sync.WaitGroup{}
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt32(&stop) == 0 {
+				m := a.Load()
+				if m.Count() != 100 {
+					t.Errorf("partial map: %d entries", m.Count())
+					return
+				}
+				// Every key of one snapshot belongs to the same generation.
+				gen, _ := m.Get("key_0")
+				for i := 0; i < 100; i++ {
+					if v, ok := m.Get(fmt.Sprintf("key_%d", i)); !ok || v != gen {
+						t.Errorf("key_%d = %d, %v in generation %d", i, v, ok, gen)
+						return
+					}
+				}
+				atomic.AddInt64(&reads, 1)
+			}
+		}()
+	}
+	for gen := 1; gen <= 50; gen++ {
+		old := a.Swap(build(gen))
+		v, _ := old.Get("key_0")
+		require.Equal(t, gen-1, v)
+		runtime.Gosched()
+	}
+	for atomic.LoadInt64(&reads) == 0 {
+		runtime.Gosched()
+	}
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+	v, _ := a.Load().Get("key_99")
+	require.Equal(t, 50, v)
+}
@@ -0,0 +1,246 @@
+package dmap
+
+import "context"
+
+// ConflictPolicy decides the value stored by SetManyWith when a key
+// already exists: it receives the existing and incoming values and
+// returns the one to keep.
+type ConflictPolicy[V any] func(existing, incoming V) V
+
+// Overwrite returns a ConflictPolicy that replaces existing values.
+func Overwrite[V any]() ConflictPolicy[V] {
+	return func(_, incoming V) V { return incoming }
+}
+
+// KeepExisting returns a ConflictPolicy that leaves existing values as is.
+func KeepExisting[V any]() ConflictPolicy[V] {
+	return func(existing, _ V) V { return existing }
+}
+
+// SetMany sets all the given key, value pairs in the map, overwriting
+// existing keys. Writes are grouped by shard and each shard's lock is
+// taken once, so the batch is atomic per shard but not across shards.
+func (m DMap[K, V]) SetMany(items map[K]V) {
+	m.SetManyWith(items, Overwrite[V]())
+}
+
+// SetManyWith is like SetMany but resolves keys that already exist in
+// the map with policy, which runs under the shard's write lock. If policy
+// panics WithRecover, the existing value is kept.
+func (m DMap[K, V]) SetManyWith(items map[K]V, policy ConflictPolicy[V]) {
+	m.setMany(items, policy, false)
+}
+
+// PutAll is like SetMany, but returns the keys that were not present
+// before, in no particular order.
+func (m DMap[K, V]) PutAll(items map[K]V) (created []K) {
+	return m.setMany(items, Overwrite[V](), true)
+}
+
+// setMany implements SetManyWith, returning the newly stored keys if
+// collect is set.
+func (m DMap[K, V]) setMany(items map[K]V, policy ConflictPolicy[V], collect bool) []K {
+	items = normalizeMap(m.config().normalizer, items)
+	defer m.evictOverflow()
+	var created []K
+	if collect {
+		created = make([]K, 0)
+	}
+	for i, keys := range m.groupByShard(items) {
+		if len(keys) == 0 {
+			continue
+		}
+		shard := m[i]
+		if shard.cfg.validator != nil {
+			keys = shard.validKeys(keys, items)
+		}
+		written := shard.locked(func() {
+			for _, key := range keys {
+				val := items[key]
+				old, ok := shard.lookup(key)
+				// An existing key whose policy panics WithRecover is kept.
+				if ok && !shard.cfg.guard(func() { val = policy(old, val) }) {
+					continue
+				}
+				stored, _ := shard.set(key, val)
+				if collect && !ok && stored {
+					created = append(created, key)
+				}
+			}
+		})
+		if !written {
+			return created
+		}
+	}
+	return created
+}
+
+// consumeBatch is how many entries ConsumeFrom collects before writing.
+const consumeBatch = 256
+
+// ConsumeFrom Sets the entries received from in until in is closed, then
+// returns nil, or until ctx is done, then returns ctx.Err(). Entries are
+// collected into batches of up to consumeBatch and written with SetMany,
+// so each shard's lock is taken once per batch; a batch is also written
+// as soon as in has no entry ready, so entries do not linger while the
+// producer is slow. Entries already received when ctx is done are
+// written before ConsumeFrom returns. A later entry for a key overwrites
+// an earlier one, as with successive Sets.
+func (m DMap[K, V]) ConsumeFrom(ctx context.Context, in <-chan Entry[K, V]) error {
+	batch := make(map[K]V, consumeBatch)
+	flush := func() {
+		if len(batch) > 0 {
+			m.SetMany(batch)
+			batch = make(map[K]V, consumeBatch)
+		}
+	}
+	defer flush()
+	for {
+		// Block for the first entry of a batch.
+		select {
+		case e, ok := <-in:
+			if !ok {
+				return nil
+			}
+			batch[e.Key] = e.Value
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		// Then take whatever else is ready.
+	fill:
+		for len(batch) < consumeBatch {
+			select {
+			case e, ok := <-in:
+				if !ok {
+					return nil
+				}
+				batch[e.Key] = e.Value
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+				break fill
+			}
+		}
+		flush()
+	}
+}
+
+// Warm primes the map from a batch source, e.g. at startup: it calls
+// loader with ctx and writes the entries it returns with SetMany. If
+// loader fails or ctx is done by the time it returns, Warm returns that
+// error and leaves the map unchanged.
+func (m DMap[K, V]) Warm(ctx context.Context, loader func(ctx context.Context) (map[K]V, error)) error {
+	items, err := loader(ctx)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.SetMany(items)
+	return nil
+}
+
+// validKeys returns the keys whose entries in items pass the
+// WithValidator, reusing the backing array of keys.
+func (s *Shard[K, V]) validKeys(keys []K, items map[K]V) []K {
+	valid := keys[:0]
+	for _, key := range keys {
+		if s.validate(key, items[key]) == nil {
+			valid = append(valid, key)
+		}
+	}
+	return valid
+}
+
+// UpdateMany replaces the value of each key of updates that is present
+// in the map with updates[key](old), skipping absent keys, and returns
+// the number of keys updated. Keys are grouped by shard and each shard's
+// write lock is taken once; the update funcs run under it, so they must
+// not access the map. A key whose func panics WithRecover is left as is.
+func (m DMap[K, V]) UpdateMany(updates map[K]func(old V) V) int {
+	updates = normalizeMap(m.config().normalizer, updates)
+	keys := make([]K, 0, len(updates))
+	for key := range updates {
+		keys = append(keys, key)
+	}
+	defer m.evictOverflow()
+	updated := 0
+	for i, group := range m.groupKeys(keys) {
+		if len(group) == 0 {
+			continue
+		}
+		shard := m[i]
+		written := shard.locked(func() {
+			for _, key := range group {
+				old, ok := shard.lookup(key)
+				if !ok {
+					continue
+				}
+				var val V
+				if shard.cfg.guard(func() { val = updates[key](old) }) && shard.validate(key, val) == nil {
+					shard.set(key, val)
+					updated++
+				}
+			}
+		})
+		if !written {
+			return updated
+		}
+	}
+	return updated
+}
+
+// GetMany returns the values of the present keys among keys.
+// Reads are grouped by shard like GetManyFunc's, so they are consistent
+// per shard but not across shards (see AtomicGetAll).
+func (m DMap[K, V]) GetMany(keys []K) map[K]V {
+	found := make(map[K]V, len(keys))
+	m.GetManyFunc(keys, func(k K, v V) { found[k] = v })
+	return found
+}
+
+// GetManyFunc calls fn for each of keys present in the map, with its
+// value as Get would return it, and skips the others. Keys are grouped
+// by shard and fn runs under each shard's read lock, taken once per
+// shard, so fn must not modify the map: a write from fn deadlocks.
+func (m DMap[K, V]) GetManyFunc(keys []K, fn func(K, V)) {
+	keys = m.normalizeKeys(keys)
+	for i, group := range m.groupKeys(keys) {
+		if len(group) > 0 {
+			m[i].getMany(group, fn)
+		}
+	}
+}
+
+// getMany calls fn for each of keys present in the shard, under its read
+// lock.
+func (s *Shard[K, V]) getMany(keys []K, fn func(K, V)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, key := range keys {
+		if v, ok := s.lookup(key); ok {
+			fn(key, s.readCopy(v))
+		}
+	}
+}
+
+// groupKeys returns keys bucketed by shard index.
+func (m DMap[K, V]) groupKeys(keys []K) [][]K {
+	groups := make([][]K, len(m))
+	for _, key := range keys {
+		i := m.getShardIndex(key)
+		groups[i] = append(groups[i], key)
+	}
+	return groups
+}
+
+// groupByShard returns the keys of items bucketed by shard index.
+func (m DMap[K, V]) groupByShard(items map[K]V) [][]K {
+	groups := make([][]K, len(m))
+	for key := range items {
+		i := m.getShardIndex(key)
+		groups[i] = append(groups[i], key)
+	}
+	return groups
+}
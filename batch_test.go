@@ -0,0 +1,190 @@
+package dmap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newOverlapTestMap() (DMap[string, int], map[string]int) {
+	m := New[string, int](4)
+	m.Set("a", 1)
+	m.Set("b", 2)
+	m.Set("c", 3)
+	items := map[string]int{"b": 20, "c": 30, "d": 40, "e": 50}
+	return m, items
+}
+
+func requireItems(t *testing.T, m DMap[string, int], want map[string]int) {
+	t.Helper()
+	require.EqualValues(t, len(want), m.Count())
+	for k, v := range want {
+		got, ok := m.Get(k)
+		require.True(t, ok, "key %s", k)
+		require.Equal(t, v, got, "key %s", k)
+	}
+}
+
+func TestSetMany(t *testing.T) {
+	m, items := newOverlapTestMap()
+	m.SetMany(items)
+	requireItems(t, m, map[string]int{"a": 1, "b": 20, "c": 30, "d": 40, "e": 50})
+}
+
+func TestSetManyWithOverwrite(t *testing.T) {
+	m, items := newOverlapTestMap()
+	m.SetManyWith(items, Overwrite[int]())
+	requireItems(t, m, map[string]int{"a": 1, "b": 20, "c": 30, "d": 40, "e": 50})
+}
+
+func TestSetManyWithKeepExisting(t *testing.T) {
+	m, items := newOverlapTestMap()
+	m.SetManyWith(items, KeepExisting[int]())
+	requireItems(t, m, map[string]int{"a": 1, "b": 2, "c": 3, "d": 40, "e": 50})
+}
+
+func TestSetManyWithMerge(t *testing.T) {
+	m, items := newOverlapTestMap()
+	m.SetManyWith(items, func(existing, incoming int) int { return existing + incoming })
+	requireItems(t, m, map[string]int{"a": 1, "b": 22, "c": 33, "d": 40, "e": 50})
+}
+
+func TestPutAll(t *testing.T) {
+	m, items := newOverlapTestMap()
+	created := m.PutAll(items)
+	require.ElementsMatch(t, []string{"d", "e"}, created)
+	requireItems(t, m, map[string]int{"a": 1, "b": 20, "c": 30, "d": 40, "e": 50})
+
+	require.Empty(t, m.PutAll(items))
+	require.Empty(t, m.PutAll(nil))
+}
+
+func TestBatchFuncsRecover(t *testing.T) {
+	var recovered []any
+	m := New[string, int](1, WithRecover[string, int](func(r any) { recovered = append(recovered, r) }))
+	m.SetMany(map[string]int{"a": 1, "b": 2})
+
+	boom := func(int) int { panic("update") }
+	require.Equal(t, 1, m.UpdateMany(map[string]func(int) int{
+		"a": boom,
+		"b": func(v int) int { return v * 10 },
+	}))
+	m.SetManyWith(map[string]int{"a": 5, "c": 3}, func(int, int) int { panic("policy") })
+	require.Equal(t, []any{"update", "policy"}, recovered)
+	requireItems(t, m, map[string]int{"a": 1, "b": 20, "c": 3})
+
+	// Without WithRecover, the panic propagates and the lock is released.
+	plain := New[string, int](1)
+	plain.Set("a", 1)
+	require.PanicsWithValue(t, "update", func() {
+		plain.UpdateMany(map[string]func(int) int{"a": boom})
+	})
+	require.PanicsWithValue(t, "policy", func() {
+		plain.SetManyWith(map[string]int{"a": 2}, func(int, int) int { panic("policy") })
+	})
+	plain.Set("a", 3)
+	requireItems(t, plain, map[string]int{"a": 3})
+}
+
+func TestGetManyFunc(t *testing.T) {
+	m, _ := newOverlapTestMap()
+	seen := map[string]int{}
+	m.GetManyFunc([]string{"a", "c", "missing", "zzz"}, func(k string, v int) {
+		_, dup := seen[k]
+		require.False(t, dup, k)
+		seen[k] = v
+	})
+	require.Equal(t, map[string]int{"a": 1, "c": 3}, seen)
+
+	require.Equal(t, map[string]int{"b": 2}, m.GetMany([]string{"b", "d"}))
+	require.Empty(t, m.GetMany(nil))
+
+	// A panicking fn leaves no read lock held.
+	require.PanicsWithValue(t, "fn", func() {
+		m.GetManyFunc([]string{"a"}, func(string, int) { panic("fn") })
+	})
+	m.Remove("a")
+	require.False(t, m.Has("a"))
+}
+
+func TestUpdateMany(t *testing.T) {
+	m, _ := newOverlapTestMap()
+	double := func(v int) int { return 2 * v }
+	n := m.UpdateMany(map[string]func(int) int{
+		"a":       double,
+		"c":       func(v int) int { return v + 100 },
+		"missing": double,
+	})
+	require.Equal(t, 2, n)
+	requireItems(t, m, map[string]int{"a": 2, "b": 2, "c": 103})
+	require.False(t, m.Has("missing"))
+	require.Zero(t, m.UpdateMany(nil))
+}
+
+func TestConsumeFrom(t *testing.T) {
+	m := New[string, int](10)
+	in := make(chan Entry[string, int], 64)
+	go func() {
+		for i := 0; i < 10000; i++ {
+			in <- Entry[string, int]{Key: fmt.Sprintf("key_%d", i%5000), Value: i}
+		}
+		close(in)
+	}()
+	require.NoError(t, m.ConsumeFrom(context.Background(), in))
+	require.EqualValues(t, 5000, m.Count())
+	for i := 5000; i < 10000; i++ {
+		v, ok := m.Get(fmt.Sprintf("key_%d", i%5000))
+		require.True(t, ok)
+		require.Equal(t, i, v, "later entries win")
+	}
+}
+
+func TestConsumeFromCancel(t *testing.T) {
+	m := New[string, int](10)
+	in := make(chan Entry[string, int])
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- m.ConsumeFrom(ctx, in) }()
+
+	in <- Entry[string, int]{Key: "a", Value: 1}
+	cancel()
+	select {
+	case err := <-errc:
+		require.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("ConsumeFrom did not return after cancel")
+	}
+	require.True(t, m.Has("a"), "received entries are written")
+}
+
+func TestWarm(t *testing.T) {
+	m := New[string, int](10)
+	want := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		want[fmt.Sprintf("key_%d", i)] = i
+	}
+	require.NoError(t, m.Warm(context.Background(), func(context.Context) (map[string]int, error) {
+		return want, nil
+	}))
+	require.Equal(t, want, m.Items())
+
+	errLoad := errors.New("source unavailable")
+	empty := New[string, int](10)
+	err := empty.Warm(context.Background(), func(context.Context) (map[string]int, error) {
+		return want, errLoad
+	})
+	require.ErrorIs(t, err, errLoad)
+	require.Zero(t, empty.Count())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err = empty.Warm(ctx, func(context.Context) (map[string]int, error) {
+		cancel()
+		return want, nil
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Zero(t, empty.Count())
+}
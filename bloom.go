@@ -0,0 +1,77 @@
+package dmap
+
+import "sync/atomic"
+
+// bloomSeed seeds the key hash of Bloom filters, so that it is
+// independent of shard placement.
+const bloomSeed = 0x9e3779b97f4a7c15
+
+// Sizing of WithBloomFilter: 10 bits per expected key and 7 probes give
+// a false positive rate of about 1% at the expected load.
+const (
+	bloomBitsPerKey = 10
+	bloomProbes     = 7
+)
+
+// bloomFilter is a Bloom filter over key hashes that is safe for
+// concurrent use: bits are set and tested atomically, so tests need no
+// lock. Bits are never cleared except by reset, so removed keys remain
+// false positives until then.
+type bloomFilter struct {
+	bits []uint64
+}
+
+func newBloomFilter(expected int) *bloomFilter {
+	words := (expected*bloomBitsPerKey + 63) / 64
+	if words < 1 {
+		words = 1
+	}
+	return &bloomFilter{bits: make([]uint64, words)}
+}
+
+// Bit i of the filter for hash h is (h1 + i*h2) mod the filter size, by
+// double hashing from the two halves of h.
+func bloomHashes(h uint64) (h1, h2 uint64) {
+	return h, (h >> 32) | (h << 32) | 1
+}
+
+func (f *bloomFilter) add(h uint64) {
+	n := uint64(len(f.bits)) * 64
+	h1, h2 := bloomHashes(h)
+	for i := uint64(0); i < bloomProbes; i++ {
+		bit := (h1 + i*h2) % n
+		word, mask := &f.bits[bit/64], uint64(1)<<(bit%64)
+		for {
+			old := atomic.LoadUint64(word)
+			if old&mask != 0 || atomic.CompareAndSwapUint64(word, old, old|mask) {
+				break
+			}
+		}
+	}
+}
+
+// mayContain reports false only if h was never added since the last
+// reset.
+func (f *bloomFilter) mayContain(h uint64) bool {
+	n := uint64(len(f.bits)) * 64
+	h1, h2 := bloomHashes(h)
+	for i := uint64(0); i < bloomProbes; i++ {
+		bit := (h1 + i*h2) % n
+		if atomic.LoadUint64(&f.bits[bit/64])&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) reset() {
+	for i := range f.bits {
+		atomic.StoreUint64(&f.bits[i], 0)
+	}
+}
+
+// definitelyAbsent reports whether the shard's Bloom filter rules key
+// out. It needs no lock.
+func (s *Shard[K, V]) definitelyAbsent(key K) bool {
+	return s.bloom != nil && !s.bloom.mayContain(hashKeySeed(key, bloomSeed))
+}
@@ -0,0 +1,86 @@
+package dmap
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBloomFilter(t *testing.T) {
+	m := New[string, int](4, WithBloomFilter[string, int](1000))
+	for i := 0; i < 4000; i++ {
+		m.Set(fmt.Sprintf("key%d", i), i)
+	}
+	// No false negatives.
+	for i := 0; i < 4000; i++ {
+		k := fmt.Sprintf("key%d", i)
+		require.False(t, m[m.getShardIndex(k)].definitelyAbsent(k), k)
+		v, ok := m.Get(k)
+		require.True(t, ok)
+		require.Equal(t, i, v)
+		require.True(t, m.Has(k))
+	}
+
+	// Definite misses are answered without the shard lock.
+	fastPathed, falsePositives := 0, 0
+	for i := 0; i < 1000; i++ {
+		k := fmt.Sprintf("missing%d", i)
+		shard := m.getShard(k)
+		if !shard.definitelyAbsent(k) {
+			falsePositives++
+			continue
+		}
+		fastPathed++
+		shard.mu.Lock()
+		_, ok := m.Get(k)
+		require.False(t, ok)
+		require.False(t, m.Has(k))
+		shard.mu.Unlock()
+	}
+	require.Greater(t, fastPathed, 900)
+	require.Less(t, falsePositives, 50)
+}
+
+func TestBloomFilterRemoveAndClear(t *testing.T) {
+	m := New[string, int](2, WithBloomFilter[string, int](10))
+	m.Set("a", 1)
+	m.Remove("a")
+	require.False(t, m.Has("a"), "a filter hit still does the real lookup")
+	require.False(t, m.getShard("a").definitelyAbsent("a"))
+
+	m.Clear()
+	require.True(t, m.getShard("a").definitelyAbsent("a"))
+	m.ReplaceAll(map[string]int{"b": 2})
+	require.True(t, m.Has("b"))
+}
+
+func BenchmarkGetMisses(b *testing.B) {
+	present := make([]string, 100000)
+	missing := make([]string, len(present))
+	for i := range present {
+		present[i] = fmt.Sprintf("key_%d", i)
+		missing[i] = fmt.Sprintf("missing_%d", i)
+	}
+	for _, bc := range []struct {
+		name string
+		opts []Option[string, int]
+	}{
+		{"plain", nil},
+		{"bloom", []Option[string, int]{WithBloomFilter[string, int](len(present) / 16)}},
+	} {
+		m := New(16, bc.opts...)
+		for i, k := range present {
+			m.Set(k, i)
+		}
+		b.Run(bc.name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					m.Get(missing[i%len(missing)])
+					i++
+				}
+			})
+		})
+	}
+}
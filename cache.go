@@ -0,0 +1,115 @@
+package dmap
+
+import (
+	"sync"
+	"time"
+)
+
+// Cache is a cache-aside wrapper over a DMap: on a miss, Get loads the
+// value with the configured loader and stores it with the configured TTL.
+// A Cache is safe for concurrent use.
+type Cache[K comparable, V any] struct {
+	m      DMap[K, V]
+	loader Loader[K, V]
+	ttl    time.Duration
+
+	// refreshing holds the keys being reloaded WithRefreshAhead, and
+	// refreshes tracks the goroutines reloading them; none are started
+	// once closed is set.
+	mu         sync.Mutex
+	refreshing map[K]struct{}
+	refreshes  sync.WaitGroup
+	closed     bool
+}
+
+// NewCache creates a Cache with nShards shards that fills misses with
+// loader and keeps loaded values for ttl (forever if ttl <= 0).
+func NewCache[K comparable, V any](nShards int, loader Loader[K, V], ttl time.Duration, opts ...Option[K, V]) *Cache[K, V] {
+	return &Cache[K, V]{
+		m:      New(nShards, opts...),
+		loader: loader,
+		ttl:    ttl,
+	}
+}
+
+// Get returns the cached value for key, loading and caching it on a miss.
+// Loader errors are returned as is and nothing is cached.
+// Concurrent misses for the same key may each call the loader.
+func (c *Cache[K, V]) Get(key K) (V, error) {
+	if v, ok := c.m.Get(key); ok {
+		if threshold := c.m.config().refreshAhead; threshold > 0 {
+			c.maybeRefresh(key, threshold)
+		}
+		return v, nil
+	}
+	v, err := c.loader(key)
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+	c.m.SetWithTTL(key, v, c.ttl)
+	return v, nil
+}
+
+// maybeRefresh starts a background reload of key if it expires within
+// threshold and is not being reloaded already.
+func (c *Cache[K, V]) maybeRefresh(key K, threshold time.Duration) {
+	key = c.m.normalize(key)
+	shard := c.m.getShard(key)
+	shard.mu.RLock()
+	due := shard.contains(key) && shard.expiresWithin(key, threshold)
+	shard.mu.RUnlock()
+	if !due {
+		return
+	}
+	c.mu.Lock()
+	if _, ok := c.refreshing[key]; ok || c.closed {
+		c.mu.Unlock()
+		return
+	}
+	if c.refreshing == nil {
+		c.refreshing = make(map[K]struct{})
+	}
+	c.refreshing[key] = struct{}{}
+	c.refreshes.Add(1)
+	c.mu.Unlock()
+
+	go func() {
+		defer c.refreshes.Done()
+		defer func() {
+			c.mu.Lock()
+			delete(c.refreshing, key)
+			c.mu.Unlock()
+		}()
+		// The map may have been closed directly, not through c.
+		if v, err := c.loader(key); err == nil && !c.m.IsClosed() {
+			c.m.SetWithTTL(key, v, c.ttl)
+		}
+	}()
+}
+
+// Invalidate drops key from the cache, so the next Get reloads it.
+func (c *Cache[K, V]) Invalidate(key K) {
+	c.m.Remove(key)
+}
+
+// InvalidateAll drops every entry from the cache.
+func (c *Cache[K, V]) InvalidateAll() {
+	c.m.Clear()
+}
+
+// Close closes the backing map (see DMap.Close), e.g. to stop a janitor
+// started WithJanitor on shutdown, once any refreshes started
+// WithRefreshAhead have finished.
+func (c *Cache[K, V]) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.refreshes.Wait()
+	return c.m.Close()
+}
+
+// Map returns the DMap backing the cache.
+func (c *Cache[K, V]) Map() DMap[K, V] {
+	return c.m
+}
@@ -0,0 +1,142 @@
+package dmap
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type countingLoader struct {
+	calls int
+	err   error
+}
+
+func (l *countingLoader) load(key string) (string, error) {
+	l.calls++
+	if l.err != nil {
+		return "", l.err
+	}
+	return "loaded " + key, nil
+}
+
+func TestCacheMissThenHit(t *testing.T) {
+	l := &countingLoader{}
+	c := NewCache[string, string](4, l.load, time.Minute)
+
+	v, err := c.Get("foo")
+	require.NoError(t, err)
+	require.Equal(t, "loaded foo", v)
+	require.Equal(t, 1, l.calls)
+
+	v, err = c.Get("foo")
+	require.NoError(t, err)
+	require.Equal(t, "loaded foo", v)
+	require.Equal(t, 1, l.calls)
+	require.True(t, c.Map().Has("foo"))
+}
+
+func TestCacheLoaderError(t *testing.T) {
+	boom := errors.New("boom")
+	l := &countingLoader{err: boom}
+	c := NewCache[string, string](4, l.load, time.Minute)
+
+	_, err := c.Get("foo")
+	require.ErrorIs(t, err, boom)
+	require.False(t, c.Map().Has("foo"))
+}
+
+func TestCacheTTLExpiryReloads(t *testing.T) {
+	l := &countingLoader{}
+	c := NewCache[string, string](4, l.load, 30*time.Millisecond)
+
+	_, err := c.Get("foo")
+	require.NoError(t, err)
+	_, err = c.Get("foo")
+	require.NoError(t, err)
+	require.Equal(t, 1, l.calls)
+
+	time.Sleep(40 * time.Millisecond)
+	v, err := c.Get("foo")
+	require.NoError(t, err)
+	require.Equal(t, "loaded foo", v)
+	require.Equal(t, 2, l.calls)
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	l := &countingLoader{}
+	c := NewCache[string, string](4, l.load, time.Minute)
+	_, _ = c.Get("foo")
+	_, _ = c.Get("bar")
+
+	c.Invalidate("foo")
+	_, _ = c.Get("foo")
+	_, _ = c.Get("bar")
+	require.Equal(t, 3, l.calls)
+
+	c.InvalidateAll()
+	require.EqualValues(t, 0, c.Map().Count())
+	_, _ = c.Get("foo")
+	_, _ = c.Get("bar")
+	require.Equal(t, 5, l.calls)
+}
+
+func TestCacheRefreshAhead(t *testing.T) {
+	clock := newFakeClock()
+	var calls atomic.Int32
+	release := make(chan struct{})
+	loader := func(key string) (string, error) {
+		if calls.Add(1) == 1 {
+			return "v1", nil
+		}
+		<-release
+		return "v2", nil
+	}
+	c := NewCache[string, string](4, loader, time.Minute,
+		WithClock[string, string](clock), WithRefreshAhead[string, string](10*time.Second))
+	defer c.Close()
+
+	v, err := c.Get("a")
+	require.NoError(t, err)
+	require.Equal(t, "v1", v)
+
+	// Outside the threshold: a plain hit.
+	clock.Advance(40 * time.Second)
+	v, _ = c.Get("a")
+	require.Equal(t, "v1", v)
+	require.Equal(t, int32(1), calls.Load())
+
+	// Inside it: reads return at once while a single refresh runs.
+	clock.Advance(15 * time.Second)
+	for i := 0; i < 5; i++ {
+		v, err = c.Get("a")
+		require.NoError(t, err)
+		require.Equal(t, "v1", v)
+	}
+	require.Eventually(t, func() bool { return calls.Load() == 2 }, time.Second, time.Millisecond)
+	close(release)
+	require.Eventually(t, func() bool {
+		v, _ := c.Map().Get("a")
+		return v == "v2"
+	}, time.Second, time.Millisecond)
+	require.Equal(t, int32(2), calls.Load())
+
+	// The refreshed entry has a new TTL.
+	clock.Advance(30 * time.Second)
+	v, _ = c.Get("a")
+	require.Equal(t, "v2", v)
+	require.Equal(t, int32(2), calls.Load())
+
+	// No refreshes start once Close has begun, even for a read that
+	// found the entry before the map was cleared.
+	clock.Advance(25 * time.Second)
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	v, _ = c.Get("a")
+	require.Equal(t, "v2", v)
+	require.Empty(t, c.refreshing)
+	require.Equal(t, int32(2), calls.Load())
+}
@@ -0,0 +1,123 @@
+package dmap
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// The compact format is a dependency-free, stable binary encoding of a
+// string-keyed, string-valued map, meant for sharing read-mostly data
+// between processes (e.g. through a memory-mapped file):
+//
+//	magic    "DMAP"
+//	version  1 byte, currently 1
+//	shards   uvarint, the shard count of the encoded map
+//	entries  uvarint, the number of entries that follow
+//	entry    uvarint key length, key bytes, uvarint value length, value bytes
+//
+// Uvarints are as in encoding/binary.
+
+const (
+	compactMagic   = "DMAP"
+	compactVersion = 1
+	// maxCompactShards caps the shard count LoadCompact accepts, so that
+	// crafted input cannot make it allocate an arbitrary number of shards.
+	maxCompactShards = 1 << 16
+)
+
+// MarshalCompact encodes m in the compact format. Shards are read one at
+// a time, so under concurrent writes the result is not a point-in-time
+// snapshot of the whole map.
+func MarshalCompact[K ~string, V ~string](m DMap[K, V]) []byte {
+	items := m.Items()
+	size := len(compactMagic) + 1 + 2*binary.MaxVarintLen64
+	for k, v := range items {
+		size += 2*binary.MaxVarintLen64 + len(k) + len(v)
+	}
+
+	buf := make([]byte, 0, size)
+	buf = append(buf, compactMagic...)
+	buf = append(buf, compactVersion)
+	buf = appendUvarint(buf, uint64(len(m)))
+	buf = appendUvarint(buf, uint64(len(items)))
+	for k, v := range items {
+		buf = appendUvarint(buf, uint64(len(k)))
+		buf = append(buf, k...)
+		buf = appendUvarint(buf, uint64(len(v)))
+		buf = append(buf, v...)
+	}
+	return buf
+}
+
+// LoadCompact decodes data produced by MarshalCompact into a new DMap
+// with the encoded shard count, configured by opts. The map is only built
+// once all of data has decoded; data encoding more than 65536 shards is
+// rejected with ErrCorruptCompact.
+func LoadCompact[K ~string, V ~string](data []byte, opts ...Option[K, V]) (DMap[K, V], error) {
+	if !bytes.HasPrefix(data, []byte(compactMagic)) || len(data) < len(compactMagic)+1 ||
+		data[len(compactMagic)] != compactVersion {
+		return nil, ErrCorruptCompact
+	}
+	r := compactReader{data: data[len(compactMagic)+1:]}
+	shards := r.uvarint()
+	entries := r.uvarint()
+	if r.err != nil || shards == 0 || shards > maxCompactShards {
+		return nil, ErrCorruptCompact
+	}
+
+	items := make(map[K]V)
+	for i := uint64(0); i < entries && r.err == nil; i++ {
+		k := r.bytes()
+		v := r.bytes()
+		items[K(k)] = V(v)
+	}
+	if r.err != nil || len(r.data) != 0 {
+		return nil, ErrCorruptCompact
+	}
+	m, err := NewE(int(shards), opts...)
+	if err != nil {
+		return nil, err
+	}
+	m.SetMany(items)
+	return m, nil
+}
+
+// appendUvarint appends the uvarint encoding of v to buf.
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+// compactReader decodes the compact format, remembering the first error.
+type compactReader struct {
+	data []byte
+	err  error
+}
+
+func (r *compactReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = ErrCorruptCompact
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *compactReader) bytes() []byte {
+	n := r.uvarint()
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.data)) {
+		r.err = ErrCorruptCompact
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
@@ -0,0 +1,63 @@
+package dmap
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompactRoundTrip(t *testing.T) {
+	m := New[string, string](7)
+	for i := 0; i < 1000; i++ {
+		m.Set(fmt.Sprintf("key_%d", i), fmt.Sprintf("value %d", i))
+	}
+	m.Set("", "empty key")
+	m.Set("empty value", "")
+
+	data := MarshalCompact(m)
+	got, err := LoadCompact[string, string](data)
+	require.NoError(t, err)
+	require.Equal(t, 7, len(got))
+	require.True(t, Equal(m, got))
+
+	var gobBuf bytes.Buffer
+	require.NoError(t, gob.NewEncoder(&gobBuf).Encode(m.Items()))
+	require.Less(t, len(data), gobBuf.Len())
+}
+
+func TestCompactNamedStringTypes(t *testing.T) {
+	type id string
+	m := New[id, string](2)
+	m.Set("a", "b")
+
+	got, err := LoadCompact[id, string](MarshalCompact(m))
+	require.NoError(t, err)
+	require.Equal(t, "b", got.Lookup("a"))
+}
+
+func TestLoadCompactCorrupt(t *testing.T) {
+	m := New[string, string](3)
+	m.Set("key", "value")
+	data := MarshalCompact(m)
+
+	for name, bad := range map[string][]byte{
+		"empty":           nil,
+		"magic":           append([]byte("XMAP"), data[4:]...),
+		"version":         append(append([]byte("DMAP"), 9), data[5:]...),
+		"truncated":       data[:len(data)-1],
+		"trailing":        append(append([]byte{}, data...), 0),
+		"zero shards":     {'D', 'M', 'A', 'P', 1, 0, 0},
+		"too many shards": appendUvarint(appendUvarint([]byte("DMAP\x01"), maxCompactShards+1), 0),
+	} {
+		_, err := LoadCompact[string, string](bad)
+		require.ErrorIs(t, err, ErrCorruptCompact, name)
+	}
+
+	// More shards than bytes of data is fine, up to the cap.
+	loaded, err := LoadCompact[string, string](MarshalCompact(New[string, string](1000)))
+	require.NoError(t, err)
+	require.Len(t, loaded, 1000)
+}
@@ -0,0 +1,195 @@
+package dmap
+
+import "runtime"
+
+// CompareAndSwap sets key to new if its current value equals old,
+// and reports whether the swap happened. Absent keys never match.
+func CompareAndSwap[K comparable, V comparable](m DMap[K, V], key K, old, new V) bool {
+	return m.CompareAndSwapFunc(key, old, new, func(a, b V) bool { return a == b })
+}
+
+// mutateRetries bounds the compare-and-swap attempts of Mutate.
+const mutateRetries = 1000
+
+// Mutate updates key optimistically: it reads the current value, computes
+// the replacement with fn, and stores it with a compare-and-swap, retrying
+// from a fresh read if another writer got in first. fn receives the
+// current value and whether key exists; if it returns ok false, Mutate
+// gives up without writing. Mutate reports whether a mutation was applied,
+// which is false too if the swap still conflicts after mutateRetries
+// attempts.
+// Unlike Compute, fn runs without any lock held, so it may be slow or
+// access the map, but it may run several times and must not have side
+// effects.
+func Mutate[K comparable, V comparable](m DMap[K, V], key K, fn func(old V, exists bool) (newV V, ok bool)) bool {
+	for i := 0; i < mutateRetries; i++ {
+		old, exists := m.Peek(key)
+		newV, ok := fn(old, exists)
+		if !ok {
+			return false
+		}
+		if exists {
+			if CompareAndSwap(m, key, old, newV) {
+				return true
+			}
+		} else if m.SetIfAbsent(key, newV) {
+			return true
+		}
+		runtime.Gosched()
+	}
+	return false
+}
+
+// CompareAndSwapFunc is like CompareAndSwap, but compares values with eq,
+// so it also works for values that are not comparable (slices, maps, ...).
+// eq runs under the shard's write lock and must not access the map.
+func (m DMap[K, V]) CompareAndSwapFunc(key K, old, new V, eq func(a, b V) bool) bool {
+	key = m.normalize(key)
+	shard := m.getShard(key)
+	if shard.validate(key, new) != nil {
+		return false
+	}
+	var stored, grew bool
+	shard.locked(func() {
+		cur, ok := shard.lookup(key)
+		equal := false
+		if ok && shard.cfg.guard(func() { equal = eq(cur, old) }) && equal {
+			stored, grew = shard.set(key, new)
+		}
+	})
+	if grew {
+		m.evictOverflow(key)
+	}
+	return stored
+}
+
+// SetIfAbsent sets key to val only if key is absent, and reports whether
+// it did.
+func (m DMap[K, V]) SetIfAbsent(key K, val V) bool {
+	key = m.normalize(key)
+	shard := m.getShard(key)
+	if shard.validate(key, val) != nil {
+		return false
+	}
+	var stored, added bool
+	shard.locked(func() {
+		if !shard.contains(key) {
+			stored, added = shard.set(key, val)
+		}
+	})
+	if added {
+		m.evictOverflow(key)
+	}
+	return stored
+}
+
+// Swap sets key to val and returns the previous value, if any, with
+// loaded reporting whether key was present. If val fails the
+// WithValidator, nothing is stored and loaded is false.
+func (m DMap[K, V]) Swap(key K, val V) (old V, loaded bool) {
+	key = m.normalize(key)
+	shard := m.getShard(key)
+	if shard.validate(key, val) != nil {
+		return old, false
+	}
+	var added bool
+	shard.locked(func() {
+		old, loaded = shard.lookup(key)
+		_, added = shard.set(key, val)
+	})
+	if added {
+		m.evictOverflow(key)
+	}
+	return shard.readCopy(old), loaded
+}
+
+// RemoveByValue deletes every entry whose value equals val and returns
+// the number of entries deleted.
+func RemoveByValue[K comparable, V comparable](m DMap[K, V], val V) int {
+	return m.DeleteFunc(func(_ K, v V) bool { return v == val })
+}
+
+// Equal reports whether a and b hold the same keys with equal values.
+// The maps may have different shard counts.
+func Equal[K comparable, V comparable](a, b DMap[K, V]) bool {
+	return EqualFunc(a, b, func(x, y V) bool { return x == y })
+}
+
+// EqualFunc is like Equal, but compares values with eq.
+// Each shard of a is copied out before it is compared against b, so no
+// two shard locks are ever held at once. Under concurrent writes the
+// result reflects no single point in time.
+func EqualFunc[K comparable, V any](a, b DMap[K, V], eq func(x, y V) bool) bool {
+	// Count includes expired entries that are not reaped yet, which the
+	// comparison below skips.
+	if a.CountLive() != b.CountLive() {
+		return false
+	}
+	for _, shard := range a {
+		shard.mu.RLock()
+		items := make(map[K]V, len(shard.items))
+		now := shard.now()
+		for k, v := range shard.items {
+			if !shard.expiredAt(k, now) {
+				items[k] = v
+			}
+		}
+		shard.mu.RUnlock()
+
+		for k, v := range items {
+			other, ok := b.Get(k)
+			if !ok || !eq(v, other) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Diff compares two snapshots of a map, e.g. for incremental sync: added
+// and removed hold the keys present only in new or only in old, and
+// changed the keys present in both with different values. Keys are in
+// no particular order. The maps may have different shard counts; each
+// is read with Items, so under concurrent writes the result reflects no
+// single point in time.
+func Diff[K comparable, V comparable](old, new DMap[K, V]) (added, removed, changed []K) {
+	before := old.Items()
+	for k, v := range new.Items() {
+		prev, ok := before[k]
+		switch {
+		case !ok:
+			added = append(added, k)
+		case prev != v:
+			changed = append(changed, k)
+		}
+		delete(before, k)
+	}
+	for k := range before {
+		removed = append(removed, k)
+	}
+	return added, removed, changed
+}
+
+// Checksum returns an order-independent hash of the contents of m, e.g.
+// to verify replicas: maps holding equal entries have equal checksums,
+// whatever their shard counts or write histories. Values are hashed like
+// keys (see hashKey).
+func Checksum[K comparable, V comparable](m DMap[K, V]) uint64 {
+	return m.ChecksumFunc(hashKey[V])
+}
+
+// ChecksumFunc is like Checksum, but hashes values with hashV, so it also
+// works for values that are not comparable.
+// Each entry is hashed from its key and value hashes, and the entry
+// hashes are XORed, so neither shard layout nor iteration order matters.
+// Under concurrent writes the result reflects no single point in time.
+func (m DMap[K, V]) ChecksumFunc(hashV func(V) uint64) uint64 {
+	var sum uint64
+	for _, shard := range m {
+		shard.forEach(func(k K, v V) bool {
+			sum ^= hashUint64(hashUint64(fnvOffset64, hashKey(k)), hashV(v))
+			return true
+		})
+	}
+	return sum
+}
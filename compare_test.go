@@ -0,0 +1,216 @@
+package dmap
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	m := New[string, int](4)
+	m.Set("a", 1)
+
+	require.False(t, CompareAndSwap(m, "a", 2, 3))
+	require.True(t, CompareAndSwap(m, "a", 1, 3))
+	require.False(t, CompareAndSwap(m, "missing", 0, 3))
+
+	v, _ := m.Get("a")
+	require.Equal(t, 3, v)
+	require.False(t, m.Has("missing"))
+}
+
+func TestCompareAndSwapFunc(t *testing.T) {
+	m := New[string, []int](4)
+	m.Set("a", []int{1, 2, 3})
+	eq := func(a, b []int) bool { return reflect.DeepEqual(a, b) }
+
+	require.False(t, m.CompareAndSwapFunc("a", []int{1, 2}, []int{9}, eq))
+	v, _ := m.Get("a")
+	require.Equal(t, []int{1, 2, 3}, v)
+
+	require.True(t, m.CompareAndSwapFunc("a", []int{1, 2, 3}, []int{9}, eq))
+	v, _ = m.Get("a")
+	require.Equal(t, []int{9}, v)
+}
+
+func TestMutate(t *testing.T) {
+	m := New[string, int](4)
+	incr := func(old int, _ bool) (int, bool) { return old + 1, true }
+
+	var applied int64
+	wg := sync.WaitGroup{}
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				if Mutate(m, "counter", incr) {
+					atomic.AddInt64(&applied, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	v, _ := m.Get("counter")
+	require.EqualValues(t, atomic.LoadInt64(&applied), v)
+	require.Positive(t, v)
+
+	// fn can refuse, leaving the map unchanged.
+	require.False(t, Mutate(m, "counter", func(old int, exists bool) (int, bool) {
+		require.True(t, exists)
+		return 0, false
+	}))
+	require.False(t, Mutate(m, "absent", func(_ int, exists bool) (int, bool) {
+		require.False(t, exists)
+		return 1, false
+	}))
+	require.False(t, m.Has("absent"))
+	require.True(t, Mutate(m, "absent", incr))
+	v, _ = m.Get("absent")
+	require.Equal(t, 1, v)
+}
+
+func TestEqual(t *testing.T) {
+	a := New[string, int](4)
+	b := New[string, int](7)
+	for i, k := range []string{"a", "b", "c"} {
+		a.Set(k, i)
+		b.Set(k, i)
+	}
+	require.True(t, Equal(a, b))
+
+	b.Set("c", 10)
+	require.False(t, Equal(a, b))
+
+	b.Set("c", 2)
+	b.Set("d", 3)
+	require.False(t, Equal(a, b))
+}
+
+func TestEqualExpired(t *testing.T) {
+	clock := newFakeClock()
+	a := New[string, int](4, WithClock[string, int](clock))
+	b := New[string, int](4, WithClock[string, int](clock))
+	a.Set("x", 1)
+	a.SetWithTTL("y", 1, time.Minute)
+	b.Set("x", 1)
+	b.Set("z", 1)
+	clock.Advance(time.Hour)
+	require.False(t, Equal(a, b))
+
+	b.Remove("z")
+	require.True(t, Equal(a, b))
+}
+
+func TestEqualFunc(t *testing.T) {
+	a := New[string, []int](4)
+	b := New[string, []int](4)
+	a.Set("a", []int{1, 2})
+	b.Set("a", []int{1, 2})
+	eq := func(x, y []int) bool { return reflect.DeepEqual(x, y) }
+
+	require.True(t, EqualFunc(a, b, eq))
+	b.Set("a", []int{2, 1})
+	require.False(t, EqualFunc(a, b, eq))
+}
+
+func TestDiff(t *testing.T) {
+	old := New[string, int](4)
+	new := New[string, int](7)
+	for i, k := range []string{"a", "b", "c", "d"} {
+		old.Set(k, i)
+	}
+	new.Set("a", 0)
+	new.Set("b", 10)
+	new.Set("d", 30)
+	new.Set("e", 4)
+	new.Set("f", 5)
+
+	added, removed, changed := Diff(old, new)
+	require.ElementsMatch(t, []string{"e", "f"}, added)
+	require.ElementsMatch(t, []string{"c"}, removed)
+	require.ElementsMatch(t, []string{"b", "d"}, changed)
+
+	added, removed, changed = Diff(old, old)
+	require.Empty(t, added)
+	require.Empty(t, removed)
+	require.Empty(t, changed)
+}
+
+func TestRemoveByValue(t *testing.T) {
+	m := New[string, string](4)
+	for _, k := range []string{"a", "b", "c"} {
+		m.Set(k, "gone")
+	}
+	m.Set("d", "kept")
+	m.Set("e", "also kept")
+
+	require.Equal(t, 3, RemoveByValue(m, "gone"))
+	require.ElementsMatch(t, []string{"d", "e"}, m.Keys())
+	require.EqualValues(t, 2, m.Count())
+	require.Zero(t, RemoveByValue(m, "gone"))
+}
+
+func TestSetIfAbsent(t *testing.T) {
+	m := New[string, int](4)
+	require.True(t, m.SetIfAbsent("a", 1))
+	require.False(t, m.SetIfAbsent("a", 2))
+	v, _ := m.Get("a")
+	require.Equal(t, 1, v)
+	require.EqualValues(t, 1, m.Count())
+}
+
+func TestSwap(t *testing.T) {
+	m := New[string, int](4)
+	old, loaded := m.Swap("a", 1)
+	require.False(t, loaded)
+	require.Zero(t, old)
+	old, loaded = m.Swap("a", 2)
+	require.True(t, loaded)
+	require.Equal(t, 1, old)
+	v, _ := m.Get("a")
+	require.Equal(t, 2, v)
+	require.EqualValues(t, 1, m.Count())
+}
+
+func TestChecksum(t *testing.T) {
+	a := New[string, int](4)
+	b := New[string, int](7)
+	for i := 0; i < 100; i++ {
+		a.Set(fmt.Sprint("k", i), i)
+	}
+	for i := 99; i >= 0; i-- {
+		b.Set(fmt.Sprint("k", i), i)
+	}
+	require.Equal(t, Checksum(a), Checksum(b))
+	require.Equal(t, Checksum(New[string, int](1)), Checksum(New[string, int](3)))
+
+	b.Set("k42", -1)
+	require.NotEqual(t, Checksum(a), Checksum(b))
+	b.Set("k42", 42)
+	require.Equal(t, Checksum(a), Checksum(b))
+	b.Set("extra", 0)
+	require.NotEqual(t, Checksum(a), Checksum(b))
+
+	// Swapping the values of two keys changes the checksum.
+	c := a.Clone()
+	c.Set("k1", 2)
+	c.Set("k2", 1)
+	require.NotEqual(t, Checksum(a), Checksum(c))
+}
+
+func TestChecksumFunc(t *testing.T) {
+	hashV := func(v []int) uint64 { return hashKey(fmt.Sprint(v)) }
+	a := New[string, []int](4)
+	b := New[string, []int](2)
+	a.Set("x", []int{1, 2})
+	b.Set("x", []int{1, 2})
+	require.Equal(t, a.ChecksumFunc(hashV), b.ChecksumFunc(hashV))
+	b.Set("x", []int{2, 1})
+	require.NotEqual(t, a.ChecksumFunc(hashV), b.ChecksumFunc(hashV))
+}
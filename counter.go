@@ -0,0 +1,62 @@
+package dmap
+
+// Increment atomically adds delta to the counter for key, treating an
+// absent key as 0, and returns the new value.
+func Increment[K comparable](m DMap[K, int64], key K, delta int64) int64 {
+	return m.Compute(key, func(old int64, _ bool) int64 {
+		return old + delta
+	})
+}
+
+// Decrement atomically subtracts delta from the counter for key,
+// treating an absent key as 0, and returns the new value.
+func Decrement[K comparable](m DMap[K, int64], key K, delta int64) int64 {
+	return Increment(m, key, -delta)
+}
+
+// IncrementFloat atomically adds delta to the gauge for key, treating an
+// absent key as 0, and returns the new value; e.g. to sum latencies.
+func IncrementFloat[K comparable](m DMap[K, float64], key K, delta float64) float64 {
+	return m.Compute(key, func(old float64, _ bool) float64 {
+		return old + delta
+	})
+}
+
+// DecrementFloat atomically subtracts delta from the gauge for key,
+// treating an absent key as 0, and returns the new value.
+func DecrementFloat[K comparable](m DMap[K, float64], key K, delta float64) float64 {
+	return IncrementFloat(m, key, -delta)
+}
+
+// DrainCounters returns the current value of every counter in m and
+// resets each one to 0, keeping the keys, e.g. to flush metrics once per
+// interval. Each shard is drained under its write lock, so every
+// concurrent Increment is counted either in the returned values or in
+// the next interval, never lost. The shards are drained one at a time,
+// so the result is not a snapshot of the map at a single moment.
+func DrainCounters[K comparable](m DMap[K, int64]) map[K]int64 {
+	counts := make(map[K]int64)
+	for _, shard := range m {
+		drain := func() {
+			now := shard.now()
+			for key, n := range shard.items {
+				if shard.expiredAt(key, now) {
+					continue
+				}
+				counts[key] = n
+				if n == 0 {
+					continue
+				}
+				expireAt, expires := shard.expires[key]
+				shard.set(key, 0)
+				if expires {
+					shard.setExpireAt(key, expireAt)
+				}
+			}
+		}
+		if !shard.locked(drain) {
+			break
+		}
+	}
+	return counts
+}
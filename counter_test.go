@@ -0,0 +1,95 @@
+package dmap
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIncrement(t *testing.T) {
+	m := New[string, int64](4)
+	require.EqualValues(t, 5, Increment(m, "hits", 5))
+	require.EqualValues(t, 7, Increment(m, "hits", 2))
+	require.EqualValues(t, 4, Decrement(m, "hits", 3))
+	require.EqualValues(t, -1, Decrement(m, "other", 1))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				Increment(m, "shared", 1)
+			}
+		}()
+	}
+	wg.Wait()
+	v, _ := m.Get("shared")
+	require.EqualValues(t, 10000, v)
+}
+
+func TestIncrementFloat(t *testing.T) {
+	m := New[string, float64](4)
+	require.Equal(t, 1.5, IncrementFloat(m, "latency", 1.5))
+	require.Equal(t, 1.0, DecrementFloat(m, "latency", 0.5))
+	require.Equal(t, -0.25, DecrementFloat(m, "other", 0.25))
+
+	const workers, perWorker = 8, 1000
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				IncrementFloat(m, "sum", 0.1)
+			}
+		}()
+	}
+	wg.Wait()
+	v, _ := m.Get("sum")
+	require.InDelta(t, workers*perWorker*0.1, v, 1e-6)
+}
+
+func TestDrainCounters(t *testing.T) {
+	m := New[string, int64](4)
+	Increment(m, "a", 3)
+	Increment(m, "b", 4)
+	require.Equal(t, map[string]int64{"a": 3, "b": 4}, DrainCounters(m))
+	require.Equal(t, map[string]int64{"a": 0, "b": 0}, DrainCounters(m))
+	require.EqualValues(t, 2, m.Count(), "keys are kept")
+
+	const workers, perWorker = 8, 2000
+	keys := []string{"a", "b", "c", "d", "e"}
+	drained := map[string]int64{}
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				Increment(m, keys[(w+i)%len(keys)], 1)
+			}
+		}(w)
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		for k, n := range DrainCounters(m) {
+			drained[k] += n
+		}
+	}
+	var total int64
+	for _, n := range drained {
+		total += n
+	}
+	require.EqualValues(t, workers*perWorker, total)
+}
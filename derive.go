@@ -0,0 +1,142 @@
+package dmap
+
+// Derived maps are built with newLike (or convertConfig when the value
+// type changes), so they keep the source's shard count and options.
+// Expired entries are left out; live entries are copied without a TTL.
+
+// Filter returns a new DMap holding the entries for which pred returns true.
+// pred runs under the source shard's read lock, so it must not modify m.
+func (m DMap[K, V]) Filter(pred func(K, V) bool) DMap[K, V] {
+	matching, _ := m.partition(pred, false)
+	return matching
+}
+
+// Clone returns a shallow copy of the map.
+func (m DMap[K, V]) Clone() DMap[K, V] {
+	return m.Filter(func(K, V) bool { return true })
+}
+
+// CopyInto sets every entry of m into dst, overwriting keys dst already
+// has, e.g. to consolidate several maps into one pre-sized destination.
+// dst may have a different shard count; entries are rehashed into it,
+// routed ones (see SetWithRoute) by their routing key.
+// Source shards are copied one at a time, so the copy is consistent per
+// shard but not across shards. Expiries are not carried over.
+func (m DMap[K, V]) CopyInto(dst DMap[K, V]) {
+	routes := m.routeTable()
+	for _, shard := range m {
+		// Snapshot first, so no source lock is held while writing to dst,
+		// which may share shards with m.
+		shard.mu.RLock()
+		now := shard.now()
+		entries := make(map[K]V, len(shard.items))
+		var routed []Entry[K, V]
+		for k, v := range shard.items {
+			if shard.expiredAt(k, now) || !m.placedOn(routes, k, shard) {
+				continue
+			}
+			if _, ok := routes[k]; ok {
+				routed = append(routed, Entry[K, V]{k, v})
+			} else {
+				entries[k] = v
+			}
+		}
+		shard.mu.RUnlock()
+		dst.SetMany(entries)
+		for _, e := range routed {
+			dst.SetWithRoute(routes[e.Key].key, e.Key, e.Value)
+		}
+	}
+}
+
+// MapValues returns a new DMap with the keys of m and the values
+// transformed by fn. fn runs under the source shard's read lock.
+func MapValues[K comparable, V, W any](m DMap[K, V], fn func(V) W) DMap[K, W] {
+	out := newWithConfig(len(m), convertConfig[K, V, W](m.config()))
+	routes := m.routeTable()
+	for i, shard := range m {
+		dst := out[i]
+		shard.mu.RLock()
+		now := shard.now()
+		for k, v := range shard.items {
+			if !shard.expiredAt(k, now) && m.placedOn(routes, k, shard) {
+				dst.set(k, fn(v))
+			}
+		}
+		shard.mu.RUnlock()
+	}
+	carryRoutes(m, routes, out)
+	return out
+}
+
+// Partition splits the map in one pass into two new DMaps: matching
+// holds the entries for which pred returns true, rest holds all the others.
+// pred runs under the source shard's read lock, so it must not modify m.
+func (m DMap[K, V]) Partition(pred func(K, V) bool) (matching, rest DMap[K, V]) {
+	return m.partition(pred, true)
+}
+
+// partition implements Partition; rest is only filled in if keepRest is set.
+func (m DMap[K, V]) partition(pred func(K, V) bool, keepRest bool) (matching, rest DMap[K, V]) {
+	matching = newLike(m)
+	if keepRest {
+		rest = newLike(m)
+	}
+	routes := m.routeTable()
+	for i, shard := range m {
+		// Placement depends only on the key (or its routing key) and
+		// shard count, so every entry lands in the same shard index in
+		// the derived maps.
+		shard.mu.RLock()
+		now := shard.now()
+		for k, v := range shard.items {
+			if shard.expiredAt(k, now) || !m.placedOn(routes, k, shard) {
+				continue
+			}
+			if pred(k, v) {
+				matching[i].set(k, v)
+			} else if keepRest {
+				rest[i].set(k, v)
+			}
+		}
+		shard.mu.RUnlock()
+	}
+	carryRoutes(m, routes, matching)
+	if keepRest {
+		carryRoutes(m, routes, rest)
+	}
+	return matching, rest
+}
+
+// splitSeed seeds the secondary hash of SplitN, so that the split is
+// independent of shard placement.
+const splitSeed = 0x5bd1e9955bd1e995
+
+// SplitN distributes the entries of m over n new DMaps by a secondary
+// hash of their keys, e.g. to export the map as n independent streams.
+// Every key goes to exactly one output, and the outputs are roughly
+// balanced. It panics with ErrInvalidShardCount if n < 1.
+func (m DMap[K, V]) SplitN(n int) []DMap[K, V] {
+	if n < 1 {
+		panic(ErrInvalidShardCount)
+	}
+	outs := make([]DMap[K, V], n)
+	for j := range outs {
+		outs[j] = newLike(m)
+	}
+	routes := m.routeTable()
+	for i, shard := range m {
+		shard.mu.RLock()
+		now := shard.now()
+		for k, v := range shard.items {
+			if !shard.expiredAt(k, now) && m.placedOn(routes, k, shard) {
+				outs[hashKeySeed(k, splitSeed)%uint64(n)][i].set(k, v)
+			}
+		}
+		shard.mu.RUnlock()
+	}
+	for _, out := range outs {
+		carryRoutes(m, routes, out)
+	}
+	return outs
+}
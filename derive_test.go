@@ -0,0 +1,169 @@
+package dmap
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartition(t *testing.T) {
+	m := New[string, int](10)
+	for i := 0; i < 1000; i++ {
+		m.Set(fmt.Sprintf("key_%d", i), i)
+	}
+
+	even, odd := m.Partition(func(_ string, v int) bool { return v%2 == 0 })
+	require.Equal(t, len(m), len(even))
+	require.Equal(t, len(m), len(odd))
+	require.EqualValues(t, 500, even.Count())
+	require.EqualValues(t, 500, odd.Count())
+	require.Equal(t, m.Count(), even.Count()+odd.Count())
+
+	for _, k := range m.Keys() {
+		v, _ := m.Get(k)
+		inEven, inOdd := even.Has(k), odd.Has(k)
+		require.True(t, inEven != inOdd, "key %s must be in exactly one result", k)
+		if inEven {
+			require.Zero(t, v%2)
+			got, _ := even.Get(k)
+			require.Equal(t, v, got)
+		} else {
+			require.NotZero(t, v%2)
+			got, _ := odd.Get(k)
+			require.Equal(t, v, got)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	m := New[string, int](10)
+	for i := 0; i < 100; i++ {
+		m.Set(fmt.Sprintf("key_%d", i), i)
+	}
+
+	got := m.Filter(func(_ string, v int) bool { return v < 10 })
+	require.EqualValues(t, 10, got.Count())
+	for i := 0; i < 10; i++ {
+		v, ok := got.Get(fmt.Sprintf("key_%d", i))
+		require.True(t, ok)
+		require.Equal(t, i, v)
+	}
+}
+
+func TestClone(t *testing.T) {
+	m := New[string, int](10)
+	for i := 0; i < 100; i++ {
+		m.Set(fmt.Sprintf("key_%d", i), i)
+	}
+
+	c := m.Clone()
+	require.True(t, Equal(m, c))
+	c.Set("key_0", 100)
+	v, _ := m.Get("key_0")
+	require.Equal(t, 0, v)
+}
+
+func TestCopyInto(t *testing.T) {
+	src := New[string, int](4)
+	for i := 0; i < 1000; i++ {
+		src.Set(fmt.Sprintf("key_%d", i), i)
+	}
+	dst := New[string, int](8)
+	dst.Set("key_0", -1)
+	dst.Set("other", 7)
+
+	src.CopyInto(dst)
+	require.EqualValues(t, 1001, dst.Count())
+	for i := 0; i < 1000; i++ {
+		v, ok := dst.Get(fmt.Sprintf("key_%d", i))
+		require.True(t, ok)
+		require.Equal(t, i, v)
+	}
+	v, _ := dst.Get("other")
+	require.Equal(t, 7, v)
+	require.EqualValues(t, 1000, src.Count())
+
+	// Copying into itself is a no-op.
+	src.CopyInto(src)
+	require.EqualValues(t, 1000, src.Count())
+}
+
+func TestMapValues(t *testing.T) {
+	m := New[string, int](10)
+	for i := 0; i < 100; i++ {
+		m.Set(fmt.Sprintf("key_%d", i), i)
+	}
+
+	got := MapValues(m, func(v int) string { return fmt.Sprint(v * 2) })
+	require.EqualValues(t, 100, got.Count())
+	v, ok := got.Get("key_21")
+	require.True(t, ok)
+	require.Equal(t, "42", v)
+}
+
+func TestDerivedMapsKeepConfig(t *testing.T) {
+	calls := 0
+	hasher := func(string) uint64 {
+		calls++
+		return 2
+	}
+	m := New[string, int](5, WithHasher[string, int](hasher), WithLockStripes[string, int](2))
+	for i := 0; i < 20; i++ {
+		m.Set(fmt.Sprintf("key_%d", i), i)
+	}
+	require.Len(t, m[2].items, 20)
+
+	half := func(_ string, v int) bool { return v < 10 }
+	matching, rest := m.Partition(half)
+	derived := map[string]DMap[string, int]{
+		"Filter":             m.Filter(half),
+		"Clone":              m.Clone(),
+		"Partition/matching": matching,
+		"Partition/rest":     rest,
+	}
+	for name, d := range derived {
+		require.Equal(t, len(m), len(d), name)
+		require.Same(t, d[0].mu, d[2].mu, name)
+		require.Equal(t, d.Count(), int64(len(d[2].items)), name)
+
+		before := calls
+		d.Set("new", 1)
+		require.Greater(t, calls, before, name)
+		require.Contains(t, d[2].items, "new", name)
+	}
+
+	mv := MapValues(m, func(v int) float64 { return float64(v) })
+	require.Equal(t, len(m), len(mv))
+	require.Len(t, mv[2].items, 20)
+}
+
+func TestSplitN(t *testing.T) {
+	m := New[string, int](8)
+	for i := 0; i < 10000; i++ {
+		m.Set(fmt.Sprintf("key%d", i), i)
+	}
+	m.SetWithTTL("expired", -1, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	parts := m.SplitN(4)
+	require.Len(t, parts, 4)
+	union := map[string]int{}
+	for _, p := range parts {
+		require.Len(t, p, len(m))
+		require.InDelta(t, 2500, p.Count(), 250)
+		for k, v := range p.Items() {
+			_, dup := union[k]
+			require.False(t, dup, k)
+			union[k] = v
+			got, ok := p.Get(k)
+			require.True(t, ok)
+			require.Equal(t, v, got)
+		}
+	}
+	require.Equal(t, m.Items(), union)
+
+	require.Len(t, m.SplitN(1), 1)
+	require.PanicsWithValue(t, ErrInvalidShardCount, func() { m.SplitN(0) })
+}
@@ -2,16 +2,70 @@
 package dmap
 
 import (
-	"crypto/sha1"
-	"fmt"
+	"container/list"
+	"reflect"
+	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Shard represents one partition of the entire data.
 type Shard[K comparable, V any] struct {
-	mu    sync.RWMutex
-	items map[K]V
-	count int
+	// reads and writes count operations WithShardOpStats. They are
+	// atomics and come first, for alignment on 32-bit platforms.
+	reads  int64
+	writes int64
+
+	// mu guards the shard; it may be shared with other shards
+	// (see WithLockStripes). stripe is the index of mu among the
+	// map's locks.
+	mu     *sync.RWMutex
+	stripe int
+	items  map[K]V
+	count  int64
+	cfg    *config[K, V]
+	state  *state[K, V]
+
+	// expires holds the deadlines of entries set with a TTL.
+	// Allocated on first use.
+	expires map[K]time.Time
+	// versions holds entry versions WithVersioning, drawn from seq.
+	versions map[K]uint64
+	seq      uint64
+	// waiters holds the channels of WaitFor calls blocked on each key.
+	waiters map[K][]chan V
+	// missing holds negative-cache tombstones (key -> expiry) for keys
+	// a loader reported as absent. Allocated on first use.
+	missing map[K]time.Time
+	// loadErrs holds the loader errors cached by GetWithLoader.
+	// Allocated on first use.
+	loadErrs map[K]loadErr
+	// dirty holds the keys set since the last flush WithWriteBehind.
+	dirty map[K]struct{}
+	// events holds the events not yet delivered WithEventBuffer.
+	events []Event[K, V]
+	// tombstones holds the expiry of the markers left by
+	// RemoveWithTombstone. Allocated on first use.
+	tombstones map[K]time.Time
+	// lru orders the keys from most to least recently used and lruElems
+	// indexes it, WithMemoryBudget. Reads update it under the read lock,
+	// so lruMu guards both.
+	lruMu    sync.Mutex
+	lru      *list.List
+	lruElems map[K]*list.Element
+	// bytes is the estimated size of the shard's values, tracked with lru.
+	bytes int64
+	// bloom holds the keys ever stored WithBloomFilter.
+	bloom *bloomFilter
+	// replicas holds the copies of other shards' entries WithReplicas,
+	// guarded by replicaMu rather than the shard lock.
+	replicaMu sync.Mutex
+	replicas  map[K]V
+	// scanKeys caches the Scan order of the keys (see scanOrder), nil
+	// once a key has been added or removed.
+	scanMu   sync.Mutex
+	scanKeys []K
 }
 
 // DMap represents a simple map structure which shards
@@ -22,22 +76,149 @@ type Shard[K comparable, V any] struct {
 // DMap is thread-safe.
 type DMap[K comparable, V any] []*Shard[K, V]
 
-// New creates a new DMap with nShards number of shards.
-func New[K comparable, V any](nShards int) DMap[K, V] {
+// Entry is a key, value pair from a DMap.
+type Entry[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+// state holds map-wide mutable state, shared by all shards of one map
+// (unlike config, it is never carried over to derived maps).
+type state[K comparable, V any] struct {
+	// 64-bit atomics first, for alignment on 32-bit platforms.
+	hits   int64
+	misses int64
+	// total mirrors the sum of the shard counts; it is only
+	// maintained WithMaxTotal.
+	total int64
+	// bytes is the estimated size of all values and tick the recency
+	// clock, both only maintained WithMemoryBudget.
+	bytes int64
+	tick  uint64
+
+	frozen int32
+	closed int32
+
+	keyLocksMu sync.Mutex
+	keyLocks   map[K]*keyLock
+
+	// routing is set once SetWithRoute is first used. routes then maps
+	// each routed key to its routing key and the shard it is stored on,
+	// and displaced lists the copies left on another shard by writes
+	// that moved a key, for evictOverflow to drop (see settle). Both are
+	// guarded by routeMu.
+	routing   int32
+	routeMu   sync.Mutex
+	routes    map[K]placement[K, V]
+	displaced []placement[K, V]
+
+	subs *subscriptions[K, V]
+
+	// stop and background control the goroutines started by
+	// WithJanitor and WithWriteBehind (see Close).
+	stop       chan struct{}
+	background sync.WaitGroup
+	closeOnce  sync.Once
+	closeErr   error
+
+	// shards is the map itself WithReplicas, for shards to reach their
+	// replicas, and for maps kept in a Pool.
+	shards DMap[K, V]
+}
+
+// New creates a new DMap with nShards number of shards,
+// configured by the given options.
+// It panics with ErrInvalidShardCount if nShards < 1; use NewE to get
+// an error instead.
+func New[K comparable, V any](nShards int, opts ...Option[K, V]) DMap[K, V] {
+	m, err := NewE(nShards, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// NewE is like New, but returns ErrInvalidShardCount if nShards < 1.
+func NewE[K comparable, V any](nShards int, opts ...Option[K, V]) (DMap[K, V], error) {
+	if nShards < 1 {
+		return nil, ErrInvalidShardCount
+	}
+	cfg := newConfig(opts)
+	if err := cfg.validate(nShards); err != nil {
+		return nil, err
+	}
+	return newWithConfig(nShards, cfg), nil
+}
+
+// newLike returns an empty DMap with the same shard count and
+// configuration as m, except for callbacks (see config.derived).
+func newLike[K comparable, V any](m DMap[K, V]) DMap[K, V] {
+	return newWithConfig(len(m), m.config().derived())
+}
+
+func newWithConfig[K comparable, V any](nShards int, cfg *config[K, V]) DMap[K, V] {
+	stripes := cfg.lockStripes
+	if stripes <= 0 || stripes > nShards {
+		stripes = nShards
+	}
+	locks := make([]sync.RWMutex, stripes)
+	st := &state[K, V]{subs: &subscriptions[K, V]{}}
 	shards := make([]*Shard[K, V], nShards)
 	for i := 0; i < nShards; i++ {
+		stripe := i % len(locks)
+		if len(cfg.stripeTable) == nShards {
+			// Assigned by Rebalance.
+			stripe = cfg.stripeTable[i]
+		}
 		shard := &Shard[K, V]{
-			items: make(map[K]V),
+			mu:     &locks[stripe],
+			stripe: stripe,
+			cfg:    cfg,
+			state:  st,
+		}
+		if !cfg.lazyShards {
+			shard.items = make(map[K]V)
+		}
+		if cfg.bloomExpected > 0 {
+			shard.bloom = newBloomFilter(cfg.bloomExpected)
 		}
 		shards[i] = shard
 	}
-	return shards
+	m := DMap[K, V](shards)
+	if cfg.replicas > 1 {
+		st.shards = m
+	}
+	m.startBackground()
+	if cfg.onNew != nil {
+		cfg.onNew(m)
+	}
+	return m
+}
+
+// config returns the configuration the map was built with.
+func (m DMap[K, V]) config() *config[K, V] {
+	return m[0].cfg
+}
+
+// state returns the map's mutable, map-wide state.
+func (m DMap[K, V]) state() *state[K, V] {
+	return m[0].state
 }
 
 func (m DMap[K, V]) getShardIndex(key K) int {
-	checksum := sha1.Sum([]byte(fmt.Sprintf("%v", key)))
-	hash := int(checksum[7]<<1 | checksum[19])
-	return hash % len(m)
+	cfg := m.config()
+	var h uint64
+	if cfg.hasher != nil {
+		h = cfg.hasher(key)
+	} else if cfg.keyEncoder != nil {
+		h = hashBytes(fnvOffset64^cfg.hashSeed, cfg.keyEncoder(key))
+	} else {
+		h = hashKeySeed(key, cfg.hashSeed)
+	}
+	if cfg.weightTable != nil {
+		return cfg.weightTable[h%uint64(len(cfg.weightTable))]
+	}
+	return int(h % uint64(len(m)))
 }
 
 func (m DMap[K, V]) getShard(key K) *Shard[K, V] {
@@ -45,72 +226,726 @@ func (m DMap[K, V]) getShard(key K) *Shard[K, V] {
 	return m[i]
 }
 
+// normalize returns key as stored WithKeyNormalizer.
+func (m DMap[K, V]) normalize(key K) K {
+	if norm := m.config().normalizer; norm != nil {
+		return norm(key)
+	}
+	return key
+}
+
+// normalizeKeys is normalize for a batch of keys. keys is not modified.
+func (m DMap[K, V]) normalizeKeys(keys []K) []K {
+	norm := m.config().normalizer
+	if norm == nil {
+		return keys
+	}
+	out := make([]K, len(keys))
+	for i, key := range keys {
+		out[i] = norm(key)
+	}
+	return out
+}
+
+// normalizeMap returns items with its keys mapped by norm, or items
+// itself if norm is nil.
+func normalizeMap[K comparable, T any](norm func(K) K, items map[K]T) map[K]T {
+	if norm == nil {
+		return items
+	}
+	out := make(map[K]T, len(items))
+	for key, val := range items {
+		out[norm(key)] = val
+	}
+	return out
+}
+
 // Get returns the value for the given key from the map.
 // If a key is not found, ok is false.
 func (m DMap[K, V]) Get(key K) (V, bool) {
+	key = m.normalize(key)
 	shard := m.getShard(key)
-	shard.mu.RLock()
-	defer shard.mu.RUnlock()
-	v, ok := shard.items[key]
+	var v V
+	ok := false
+	if !shard.definitelyAbsent(key) {
+		if !shard.state.isFrozen() {
+			shard.mu.RLock()
+			defer shard.mu.RUnlock()
+		}
+		v, ok = shard.lookup(key)
+		if !ok && shard.cfg.replicas > 1 {
+			if _, exists := shard.items[key]; !exists {
+				v, ok = m.getReplica(key)
+			}
+		}
+	}
+	if shard.cfg.hitStats {
+		shard.state.recordLookup(ok)
+	}
+	if shard.cfg.opStats {
+		atomic.AddInt64(&shard.reads, 1)
+	}
+	if ok {
+		if shard.cfg.sizeOf != nil && !shard.state.isFrozen() {
+			shard.touch(key)
+		}
+		v = shard.readCopy(v)
+	}
 	return v, ok
 }
 
+// Peek is like Get, but leaves no trace: it does not count as a use of
+// key for eviction (see WithMemoryBudget), nor in hit or operation
+// statistics, so monitoring can sample the map without skewing it.
+func (m DMap[K, V]) Peek(key K) (V, bool) {
+	key = m.normalize(key)
+	shard := m.getShard(key)
+	if !shard.state.isFrozen() {
+		shard.mu.RLock()
+		defer shard.mu.RUnlock()
+	}
+	v, ok := shard.lookup(key)
+	if ok {
+		v = shard.readCopy(v)
+	}
+	return v, ok
+}
+
+// Lookup returns the value for key, or the zero value of V if key is not
+// found. Unlike Get it has a single result, so it can be used from
+// text/template and html/template, e.g. {{ .Lookup "key" }}.
+// (The template builtin index does not work on a DMap: its underlying
+// type is a slice of shards, so index expects an integer.)
+func (m DMap[K, V]) Lookup(key K) V {
+	v, _ := m.Get(key)
+	return v
+}
+
 // Set sets the given key, value in the map.
+// Values rejected WithValidator are dropped.
 func (m DMap[K, V]) Set(key K, val V) {
+	key = m.normalize(key)
 	shard := m.getShard(key)
-	shard.mu.Lock()
-	defer shard.mu.Unlock()
-	shard.items[key] = val
-	shard.count += 1
+	if shard.validate(key, val) != nil {
+		return
+	}
+	var grew bool
+	if shard.locked(func() { _, grew = shard.set(key, val) }) && grew {
+		m.evictOverflow(key)
+	}
+}
+
+// SetValidated is like Set, but returns the error of the WithValidator
+// if it rejects val, in which case nothing is stored.
+func (m DMap[K, V]) SetValidated(key K, val V) error {
+	key = m.normalize(key)
+	shard := m.getShard(key)
+	if err := shard.validate(key, val); err != nil {
+		return err
+	}
+	var grew bool
+	if !shard.locked(func() { _, grew = shard.set(key, val) }) {
+		return ErrClosed
+	}
+	if grew {
+		m.evictOverflow(key)
+	}
+	return nil
+}
+
+// validate checks key, val with the WithValidator, if any. A validator
+// that panics WithRecover rejects the entry with ErrCallbackPanicked.
+func (s *Shard[K, V]) validate(key K, val V) error {
+	if s.cfg.validator == nil {
+		return nil
+	}
+	err := ErrCallbackPanicked
+	s.cfg.guard(func() { err = s.cfg.validator(key, val) })
+	return err
+}
+
+// TrySet is like Set, but reports whether val was stored: false if it
+// fails the WithValidator, or if the map is full and rejects new keys
+// (see WithRejectOnFull).
+func (m DMap[K, V]) TrySet(key K, val V) bool {
+	key = m.normalize(key)
+	shard := m.getShard(key)
+	if shard.validate(key, val) != nil {
+		return false
+	}
+	var stored, grew bool
+	shard.locked(func() { stored, grew = shard.set(key, val) })
+	if grew {
+		m.evictOverflow(key)
+	}
+	return stored
+}
+
+// lookup returns the value for key, treating expired entries as absent.
+// The caller must hold the shard's lock.
+func (s *Shard[K, V]) lookup(key K) (V, bool) {
+	v, ok := s.items[key]
+	if ok && s.expired(key) {
+		var zero V
+		return zero, false
+	}
+	return v, ok
+}
+
+// contains is like lookup, without copying the value out.
+func (s *Shard[K, V]) contains(key K) bool {
+	_, ok := s.items[key]
+	return ok && !s.expired(key)
+}
+
+// set stores key, val in the shard and reports whether it did, which is
+// false only for a new key rejected WithRejectOnFull, and whether the map
+// may have outgrown its limits: key is new, moved here from the shard it
+// was routed to or, WithMemoryBudget, its value grew. Callers then run
+// evictOverflow. Any expiry on key is
+// cleared. The caller must hold the shard's write lock.
+func (s *Shard[K, V]) set(key K, val V) (stored, grew bool) {
+	if s.items == nil {
+		s.items = make(map[K]V)
+	}
+	if s.bloom != nil {
+		s.bloom.add(hashKeySeed(key, bloomSeed))
+	}
+	old, exists := s.items[key]
+	live := exists && !s.expired(key)
+	if s.cfg.opStats {
+		atomic.AddInt64(&s.writes, 1)
+	}
+	if !exists {
+		if s.cfg.maxTotal > 0 && !s.reserve() {
+			if s.cfg.onFull != nil {
+				s.cfg.guard(func() { s.cfg.onFull(key, val) })
+			}
+			return false, false
+		}
+		s.count += 1
+		s.scanKeys = nil
+		delete(s.missing, key)
+		delete(s.loadErrs, key)
+	}
+	s.items[key] = val
+	grew = !exists
+	if atomic.LoadInt32(&s.state.routing) != 0 && s.settle(key) {
+		grew = true
+	}
+	if s.cfg.sizeOf != nil && s.track(key, val) > 0 {
+		grew = true
+	}
+	delete(s.expires, key)
+	delete(s.tombstones, key)
+	if s.cfg.replicas > 1 {
+		s.replicate(key, val, false)
+	}
+	if s.cfg.flush != nil {
+		s.markDirty(key)
+	}
+	if s.cfg.versioning {
+		s.bumpVersion(key)
+	}
+	if len(s.waiters) > 0 {
+		s.wake(key, val)
+	}
+	s.publish(EventSet, key, val)
+	if live {
+		if s.cfg.onUpdate != nil {
+			s.cfg.guard(func() { s.cfg.onUpdate(key, old, val) })
+		}
+	} else if s.cfg.onInsert != nil {
+		s.cfg.guard(func() { s.cfg.onInsert(key, val) })
+	}
+	if s.cfg.shardMaxEntries > 0 || s.cfg.shardMaxBytes > 0 {
+		s.evictToLimits(key)
+	}
+	return true, grew
+}
+
+// delete removes key from the shard and reports whether it was present.
+// The caller must hold the shard's write lock.
+func (s *Shard[K, V]) delete(key K) bool {
+	old, ok := s.remove(key)
+	if ok {
+		s.publish(EventDelete, key, old)
+	}
+	return ok
+}
+
+// remove is delete without publishing an event. WithReplicas, it also
+// removes the replicas of key, even if the primary entry was dropped
+// (see DropPrimary).
+// The caller must hold the shard's write lock.
+func (s *Shard[K, V]) remove(key K) (V, bool) {
+	if s.cfg.replicas > 1 {
+		var zero V
+		s.replicate(key, zero, true)
+	}
+	return s.removePrimary(key)
+}
+
+// removePrimary removes the entry for key from the shard, leaving any
+// replicas alone.
+// The caller must hold the shard's write lock.
+func (s *Shard[K, V]) removePrimary(key K) (V, bool) {
+	if s.cfg.opStats {
+		atomic.AddInt64(&s.writes, 1)
+	}
+	old, ok := s.items[key]
+	if !ok {
+		return old, false
+	}
+	delete(s.items, key)
+	s.scanKeys = nil
+	delete(s.expires, key)
+	delete(s.versions, key)
+	if atomic.LoadInt32(&s.state.routing) != 0 {
+		s.unroute(key)
+	}
+	if s.cfg.sizeOf != nil {
+		s.untrack(key)
+	}
+	s.count -= 1
+	if s.cfg.maxTotal > 0 {
+		atomic.AddInt64(&s.state.total, -1)
+	}
+	return old, true
+}
+
+// Compute atomically replaces the value for key with fn(old, exists),
+// where exists reports whether key was present, and returns the new value.
+// If the new value fails the WithValidator, the entry is left as is and
+// its current value returned.
+// fn runs under the shard's write lock, so it must not access the map.
+func (m DMap[K, V]) Compute(key K, fn func(old V, exists bool) V) V {
+	key = m.normalize(key)
+	val, added := m.getShard(key).compute(key, fn)
+	if added {
+		m.evictOverflow(key)
+	}
+	return val
+}
+
+func (s *Shard[K, V]) compute(key K, fn func(old V, exists bool) V) (V, bool) {
+	if !s.lockWrite() {
+		var zero V
+		return zero, false
+	}
+	defer s.mu.Unlock()
+	old, ok := s.lookup(key)
+	var val V
+	if !s.cfg.guard(func() { val = fn(old, ok) }) || s.validate(key, val) != nil {
+		return old, false
+	}
+	_, grew := s.set(key, val)
+	return val, grew
+}
+
+// GetRef calls fn with a pointer to the value for key, under the shard's
+// write lock, and stores the value back if fn returns true, e.g. to
+// update a field of a large struct value in place. Go map values are not
+// addressable, so fn works on a copy taken under the lock; if fn returns
+// false, its changes are discarded. GetRef reports whether key was
+// present; fn is not called otherwise. fn must not retain the pointer
+// beyond the call or access the map.
+func (m DMap[K, V]) GetRef(key K, fn func(*V) bool) bool {
+	key = m.normalize(key)
+	shard := m.getShard(key)
+	ok, grew := false, false
+	shard.locked(func() {
+		var v V
+		if v, ok = shard.lookup(key); !ok {
+			return
+		}
+		changed := false
+		if shard.cfg.guard(func() { changed = fn(&v) }) && changed && shard.validate(key, v) == nil {
+			_, grew = shard.set(key, v)
+		}
+	})
+	if grew {
+		m.evictOverflow(key)
+	}
+	return ok
 }
 
 // Keys returns a list of all keys in the map (from all shards).
+// Shards are read concurrently on up to GOMAXPROCS goroutines
+// (see WithFanOutLimit).
 func (m DMap[K, V]) Keys() []K {
 	keys := make([]K, 0)
+	mu := sync.Mutex{}
+	m.fanOut(func(shard *Shard[K, V]) {
+		shard.mu.RLock()
+		defer shard.mu.RUnlock()
+		now := shard.now()
+		mu.Lock()
+		defer mu.Unlock()
+		for key := range shard.items {
+			if !shard.expiredAt(key, now) {
+				keys = append(keys, key)
+			}
+		}
+	})
+	return keys
+}
 
-	wg := sync.WaitGroup{}
-	wg.Add(len(m))
+// Values returns a list of all values in the map (from all shards).
+// Values are copied WithCopyOnRead.
+func (m DMap[K, V]) Values() []V {
+	values := make([]V, 0)
+	mu := sync.Mutex{}
+	m.fanOut(func(shard *Shard[K, V]) {
+		shard.mu.RLock()
+		defer shard.mu.RUnlock()
+		now := shard.now()
+		mu.Lock()
+		defer mu.Unlock()
+		for key, val := range shard.items {
+			if !shard.expiredAt(key, now) {
+				values = append(values, shard.readCopy(val))
+			}
+		}
+	})
+	return values
+}
 
+// Items returns a snapshot of all key, value pairs in the map as a Go map.
+// Values are copied WithCopyOnRead.
+func (m DMap[K, V]) Items() map[K]V {
+	items := make(map[K]V)
 	mu := sync.Mutex{}
+	m.fanOut(func(shard *Shard[K, V]) {
+		shard.mu.RLock()
+		defer shard.mu.RUnlock()
+		now := shard.now()
+		mu.Lock()
+		defer mu.Unlock()
+		for key, val := range shard.items {
+			if !shard.expiredAt(key, now) {
+				items[key] = shard.readCopy(val)
+			}
+		}
+	})
+	return items
+}
+
+// readCopy returns val as handed out by reads, copied WithCopyOnRead.
+func (s *Shard[K, V]) readCopy(val V) V {
+	if s.cfg.copyOnRead != nil {
+		return s.cfg.copyOnRead(val)
+	}
+	return val
+}
 
+// ForEach calls fn for every key, value in the map, one shard at a time.
+// Iteration stops early if fn returns false.
+// The shard's read lock is held while fn runs, so fn must not modify the
+// map: a write from fn to the shard being iterated deadlocks.
+func (m DMap[K, V]) ForEach(fn func(K, V) bool) {
 	for _, shard := range m {
-		go func(shard *Shard[K, V]) {
-			shard.mu.RLock()
-			defer shard.mu.RUnlock()
+		if !shard.forEach(fn) {
+			return
+		}
+	}
+}
 
-			mu.Lock()
-			for key := range shard.items {
-				keys = append(keys, key)
+// ForEachShard calls fn once per shard, in index order, with a copy of
+// the shard's entries, e.g. to checkpoint a map incrementally. Each copy
+// is a consistent snapshot of its shard. The shard's read lock is held
+// only while copying, so unlike ForEach, fn may modify the map.
+// Values are copied WithCopyOnRead.
+func (m DMap[K, V]) ForEachShard(fn func(index int, entries map[K]V)) {
+	for i, shard := range m {
+		fn(i, shard.snapshot())
+	}
+}
+
+// ForEachBatch calls fn with the entries of the map in batches of
+// batchSize, e.g. for bulk writes downstream, stopping early if fn returns
+// false. Batches never span shards, so the last batch of each shard may
+// be smaller; a batchSize below 1 is treated as 1. Like ForEachShard,
+// each shard is copied out under its read lock before fn runs, so fn may
+// modify the map and may keep the batches it is given.
+func (m DMap[K, V]) ForEachBatch(batchSize int, fn func([]Entry[K, V]) bool) {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	all := func(K, V) bool { return true }
+	for _, shard := range m {
+		entries := shard.filter(all)
+		for len(entries) > 0 {
+			n := min(batchSize, len(entries))
+			if !fn(entries[:n:n]) {
+				return
+			}
+			entries = entries[n:]
+		}
+	}
+}
+
+// ForEachParallel calls fn for every key, value in the map, processing
+// shards concurrently on up to GOMAXPROCS goroutines (see WithFanOutLimit).
+// Each goroutine holds only the read lock of the shard it is processing.
+// fn is invoked concurrently and must be safe for concurrent use;
+// like ForEach, it must not modify the map.
+func (m DMap[K, V]) ForEachParallel(fn func(K, V)) {
+	m.fanOut(func(shard *Shard[K, V]) {
+		shard.forEach(func(k K, v V) bool {
+			fn(k, v)
+			return true
+		})
+	})
+}
+
+// Any reports whether pred returns true for at least one entry.
+// Shards are searched concurrently (see WithFanOutLimit), and the search
+// stops as soon as a match is found, so pred may not see every entry.
+// pred must be safe for concurrent use and must not modify the map.
+// An empty map has no matching entry.
+func (m DMap[K, V]) Any(pred func(K, V) bool) bool {
+	var found int32
+	m.fanOut(func(shard *Shard[K, V]) {
+		if atomic.LoadInt32(&found) != 0 {
+			return
+		}
+		shard.forEach(func(k K, v V) bool {
+			if pred(k, v) {
+				atomic.StoreInt32(&found, 1)
+				return false
+			}
+			return atomic.LoadInt32(&found) == 0
+		})
+	})
+	return found != 0
+}
+
+// All reports whether pred returns true for every entry, stopping at the
+// first entry for which it does not. It is the complement of Any and has
+// the same concurrency requirements on pred.
+// All is true for an empty map.
+func (m DMap[K, V]) All(pred func(K, V) bool) bool {
+	return !m.Any(func(k K, v V) bool { return !pred(k, v) })
+}
+
+func (s *Shard[K, V]) forEach(fn func(K, V) bool) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := s.now()
+	for k, v := range s.items {
+		if s.expiredAt(k, now) {
+			continue
+		}
+		next := false
+		if !s.cfg.guard(func() { next = fn(k, v) }) || !next {
+			return false
+		}
+	}
+	return true
+}
+
+// fanOut runs fn once for every shard using at most GOMAXPROCS
+// goroutines, or the WithFanOutLimit.
+// If fn panics, the remaining shards are still processed and the first
+// panic is re-raised on the calling goroutine.
+func (m DMap[K, V]) fanOut(fn func(shard *Shard[K, V])) {
+	workers := m.config().fanOutLimit
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(m) {
+		workers = len(m)
+	}
+	next := make(chan *Shard[K, V])
+	wg := sync.WaitGroup{}
+	wg.Add(workers)
+	var panicOnce sync.Once
+	var panicked any
+	call := func(shard *Shard[K, V]) {
+		defer func() {
+			if r := recover(); r != nil {
+				panicOnce.Do(func() { panicked = r })
 			}
-			mu.Unlock()
-			wg.Done()
-		}(shard)
+		}()
+		fn(shard)
 	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for shard := range next {
+				call(shard)
+			}
+		}()
+	}
+	for _, shard := range m {
+		next <- shard
+	}
+	close(next)
 	wg.Wait()
-	return keys
+	if panicked != nil {
+		panic(panicked)
+	}
 }
 
 // Remove deletes the key from the map (if found).
 func (m DMap[K, V]) Remove(key K) {
+	key = m.normalize(key)
 	shard := m.getShard(key)
-	shard.mu.Lock()
+	if !shard.lockWrite() {
+		return
+	}
+	defer shard.mu.Unlock()
+	shard.delete(key)
+}
+
+// DeleteFunc deletes every entry for which pred returns true and returns
+// the number of entries deleted. Each shard is write-locked in turn while
+// pred runs over it, so pred must not access the map.
+func (m DMap[K, V]) DeleteFunc(pred func(K, V) bool) int {
+	removed := 0
+	for _, shard := range m {
+		removed += shard.deleteFunc(pred)
+	}
+	return removed
+}
+
+func (s *Shard[K, V]) deleteFunc(pred func(K, V) bool) int {
+	if !s.lockWrite() {
+		return 0
+	}
+	defer s.mu.Unlock()
+	removed := 0
+	now := s.now()
+	for k, v := range s.items {
+		if !s.expiredAt(k, now) && pred(k, v) {
+			s.delete(k)
+			removed++
+		}
+	}
+	return removed
+}
+
+// RemoveE is like Remove, but returns ErrKeyNotFound if key is absent.
+func (m DMap[K, V]) RemoveE(key K) error {
+	key = m.normalize(key)
+	shard := m.getShard(key)
+	if !shard.lockWrite() {
+		return ErrClosed
+	}
 	defer shard.mu.Unlock()
-	delete(shard.items, key)
+	if !shard.delete(key) {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+// Clear removes all items from the map, one shard at a time.
+// The shards keep their allocated capacity.
+func (m DMap[K, V]) Clear() {
+	for _, shard := range m {
+		if !shard.locked(shard.clear) {
+			return
+		}
+	}
+}
+
+// clear removes all items from the shard.
+// The caller must hold the shard's write lock.
+func (s *Shard[K, V]) clear() {
+	for k := range s.items {
+		delete(s.items, k)
+	}
+	s.scanKeys = nil
+	s.missing = nil
+	s.loadErrs = nil
+	if atomic.LoadInt32(&s.state.routing) != 0 {
+		s.unrouteAll()
+	}
+	if s.cfg.replicas > 1 {
+		// Clear empties every shard, so these replicas go too.
+		s.replicaMu.Lock()
+		s.replicas = nil
+		s.replicaMu.Unlock()
+	}
+	s.expires = nil
+	s.versions = nil
+	s.tombstones = nil
+	if s.cfg.sizeOf != nil {
+		s.untrackAll()
+	}
+	if s.bloom != nil {
+		s.bloom.reset()
+	}
+	if s.cfg.maxTotal > 0 {
+		atomic.AddInt64(&s.state.total, -s.count)
+	}
+	s.count = 0
 }
 
 // Count returns the total number of items in the map (across all shards).
+// Each shard keeps its own count, updated under the shard lock writers
+// already hold, and Count sums them. This costs O(shards) per call but
+// keeps writes free of a map-wide atomic counter, whose cache line would
+// bounce between cores under write-heavy load (see BenchmarkSetParallel).
+// Counts are int64 throughout, so totals do not wrap on 32-bit platforms.
+// There a shard, being a Go map, holds at most math.MaxInt32 items, and
+// the map as a whole up to that times the shard count.
 func (m DMap[K, V]) Count() int64 {
-	count := 0
+	var count int64
 	for i := 0; i < len(m); i++ {
 		shard := m[i]
 		shard.mu.RLock()
 		count += shard.count
 		shard.mu.RUnlock()
 	}
-	return int64(count)
+	return count
 }
 
+// Has reports whether key is present in the map.
+// Unlike Get, it does not copy the value out.
 func (m DMap[K, V]) Has(key K) bool {
-	_, ok := m.Get(key)
-	return ok
+	key = m.normalize(key)
+	shard := m.getShard(key)
+	if shard.cfg.opStats {
+		atomic.AddInt64(&shard.reads, 1)
+	}
+	if shard.definitelyAbsent(key) {
+		return false
+	}
+	if !shard.state.isFrozen() {
+		shard.mu.RLock()
+		defer shard.mu.RUnlock()
+	}
+	if shard.contains(key) {
+		return true
+	}
+	if shard.cfg.replicas > 1 {
+		// As in Get.
+		if _, exists := shard.items[key]; !exists {
+			_, ok := m.getReplica(key)
+			return ok
+		}
+	}
+	return false
+}
+
+// Shards returns the number of shards the map was built with. Prefer it
+// to len(m), which relies on a DMap being a slice of shards.
+func (m DMap[K, V]) Shards() int {
+	return len(m)
+}
+
+// KeyType returns the key type K the map was instantiated with.
+// It is derived from the type, not from stored keys, so it is exact even
+// for interface key types and empty maps.
+func (m DMap[K, V]) KeyType() reflect.Type {
+	return reflect.TypeOf((*K)(nil)).Elem()
+}
+
+// ValueType returns the value type V the map was instantiated with.
+// For interface value types such as any, it is the interface type itself,
+// not the dynamic type of any stored value.
+func (m DMap[K, V]) ValueType() reflect.Type {
+	return reflect.TypeOf((*V)(nil)).Elem()
 }
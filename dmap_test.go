@@ -2,8 +2,13 @@ package dmap
 
 import (
 	"fmt"
+	"html/template"
+	"math"
 	"math/rand"
 	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -30,6 +35,15 @@ func TestNew(t *testing.T) {
 	require.Equal(t, 10, len(m))
 }
 
+func TestShards(t *testing.T) {
+	for _, n := range []int{1, 7, 64} {
+		require.Equal(t, n, New[string, int](n).Shards())
+	}
+	m := New[int, int](16, WithLockStripes[int, int](4))
+	require.Equal(t, 16, m.Shards())
+	require.Equal(t, 16, m.Clone().Shards())
+}
+
 func TestSetGetWithStrKV(t *testing.T) {
 	m := New[string, string](10)
 	prepareTestData(m, 10000, "some val")
@@ -49,6 +63,42 @@ func TestKeys(t *testing.T) {
 	require.ElementsMatch(t, got, keys)
 }
 
+func TestValues(t *testing.T) {
+	m := New[string, int](10)
+	want := make([]int, 100)
+	for i := range want {
+		want[i] = i
+		m.Set(fmt.Sprintf("key_%d", i), i)
+	}
+
+	require.ElementsMatch(t, want, m.Values())
+}
+
+func TestItems(t *testing.T) {
+	m := New[string, int](10)
+	want := map[string]int{}
+	for i := 0; i < 100; i++ {
+		k := fmt.Sprintf("key_%d", i)
+		want[k] = i
+		m.Set(k, i)
+	}
+
+	require.Equal(t, want, m.Items())
+}
+
+func TestLookup(t *testing.T) {
+	m := New[string, string](4)
+	m.Set("name", "<dmap>")
+	require.Equal(t, "<dmap>", m.Lookup("name"))
+	require.Equal(t, "", m.Lookup("missing"))
+
+	tmpl := template.Must(template.New("t").Parse(
+		`{{ .Lookup "name" }}|{{ .Lookup "missing" }}|{{ with .Lookup "missing" }}set{{ else }}unset{{ end }}`))
+	var buf strings.Builder
+	require.NoError(t, tmpl.Execute(&buf, m))
+	require.Equal(t, "&lt;dmap&gt;||unset", buf.String())
+}
+
 func TestHas(t *testing.T) {
 	m := New[string, string](10)
 	prepareTestData(m, 10000, "some val")
@@ -69,6 +119,240 @@ func TestCount(t *testing.T) {
 	require.EqualValues(t, 10000, got)
 }
 
+func TestCompute(t *testing.T) {
+	m := New[string, int](10)
+	incr := func(old int, exists bool) int {
+		if !exists {
+			return 1
+		}
+		return old + 1
+	}
+
+	require.Equal(t, 1, m.Compute("a", incr))
+	require.Equal(t, 2, m.Compute("a", incr))
+	v, ok := m.Get("a")
+	require.True(t, ok)
+	require.Equal(t, 2, v)
+	require.EqualValues(t, 1, m.Count())
+}
+
+func TestCountAfterOverwriteAndRemove(t *testing.T) {
+	m := New[string, int](4)
+	m.Set("a", 1)
+	m.Set("a", 2)
+	m.Set("b", 1)
+	m.Remove("b")
+	m.Remove("missing")
+
+	require.EqualValues(t, 1, m.Count())
+
+	// Stats reads the same per-shard counts.
+	for i := 0; i < 10; i++ {
+		m.Set("a", i)
+	}
+	m.Remove("b")
+	stats := m.Stats()
+	require.EqualValues(t, 1, stats.Count)
+	require.EqualValues(t, 1, stats.MaxShardCount)
+	require.Zero(t, stats.MinShardCount)
+}
+
+func TestDeleteFunc(t *testing.T) {
+	m := New[string, int](10)
+	for i := 0; i < 100; i++ {
+		m.Set(fmt.Sprintf("key_%d", i), i)
+	}
+
+	removed := m.DeleteFunc(func(_ string, v int) bool { return v >= 50 })
+	require.Equal(t, 50, removed)
+	require.EqualValues(t, 50, m.Count())
+	require.True(t, m.Has("key_49"))
+	require.False(t, m.Has("key_50"))
+}
+
+func TestClear(t *testing.T) {
+	m := New[string, string](10)
+	prepareTestData(m, 1000, "some val")
+
+	m.Clear()
+	require.EqualValues(t, 0, m.Count())
+	require.Empty(t, m.Keys())
+	require.False(t, m.Has(keys[0]))
+
+	m.Set("foo", "bar")
+	require.EqualValues(t, 1, m.Count())
+}
+
+func TestForEach(t *testing.T) {
+	m := New[string, string](10)
+	prepareTestData(m, 10000, "some val")
+
+	got := make([]string, 0, len(keys))
+	m.ForEach(func(k, v string) bool {
+		require.Equal(t, "some val", v)
+		got = append(got, k)
+		return true
+	})
+	require.ElementsMatch(t, keys, got)
+
+	n := 0
+	m.ForEach(func(_, _ string) bool {
+		n++
+		return n < 5
+	})
+	require.Equal(t, 5, n)
+}
+
+func TestGetRef(t *testing.T) {
+	m := New[string, largeValue](4)
+	m.Set("a", largeValue{})
+
+	require.True(t, m.GetRef("a", func(v *largeValue) bool {
+		v.payload[0] = 1
+		v.payload[4095] = 2
+		return true
+	}))
+	v, _ := m.Get("a")
+	require.EqualValues(t, 1, v.payload[0])
+	require.EqualValues(t, 2, v.payload[4095])
+
+	require.True(t, m.GetRef("a", func(v *largeValue) bool {
+		v.payload[0] = 9
+		return false
+	}))
+	v, _ = m.Get("a")
+	require.EqualValues(t, 1, v.payload[0], "discarded when fn returns false")
+
+	called := false
+	require.False(t, m.GetRef("missing", func(*largeValue) bool {
+		called = true
+		return true
+	}))
+	require.False(t, called)
+	require.False(t, m.Has("missing"))
+}
+
+func TestForEachShard(t *testing.T) {
+	m := New[string, string](10)
+	prepareTestData(m, 1000, "some val")
+
+	var visited []int
+	union := map[string]string{}
+	m.ForEachShard(func(i int, entries map[string]string) {
+		visited = append(visited, i)
+		for k, v := range entries {
+			require.Equal(t, i, m.getShardIndex(k))
+			if !strings.HasPrefix(k, "written_") {
+				union[k] = v
+			}
+		}
+		// The lock is not held, so fn may write to the map.
+		m.Set(fmt.Sprintf("written_%d", i), "")
+	})
+	require.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, visited)
+	require.Len(t, union, 1000)
+	for _, k := range keys {
+		require.Equal(t, "some val", union[k])
+	}
+}
+
+func TestForEachBatch(t *testing.T) {
+	m := New[string, string](10)
+	prepareTestData(m, 1000, "some val")
+
+	union := map[string]string{}
+	perShard := make([]int, len(m))
+	m.ForEachBatch(16, func(batch []Entry[string, string]) bool {
+		require.NotEmpty(t, batch)
+		require.LessOrEqual(t, len(batch), 16)
+		shard := m.getShardIndex(batch[0].Key)
+		for _, e := range batch {
+			require.Equal(t, shard, m.getShardIndex(e.Key), "batches do not span shards")
+			union[e.Key] = e.Value
+		}
+		// Only the last batch of a shard may be short.
+		require.Zero(t, perShard[shard]%16)
+		perShard[shard] += len(batch)
+		return true
+	})
+	require.Len(t, union, 1000)
+	for _, k := range keys {
+		require.Equal(t, "some val", union[k])
+	}
+	for i, shard := range m {
+		require.EqualValues(t, shard.count, perShard[i])
+	}
+
+	batches := 0
+	m.ForEachBatch(16, func([]Entry[string, string]) bool {
+		batches++
+		return batches < 3
+	})
+	require.Equal(t, 3, batches, "stops when fn returns false")
+}
+
+func TestForEachParallel(t *testing.T) {
+	m := New[string, int](10)
+	prepareTestData(m, 10000, 1)
+
+	var total int64
+	visits := New[string, *int64](10)
+	for _, k := range keys {
+		visits.Set(k, new(int64))
+	}
+	m.ForEachParallel(func(k string, v int) {
+		atomic.AddInt64(&total, int64(v))
+		n, _ := visits.Get(k)
+		atomic.AddInt64(n, 1)
+	})
+	require.EqualValues(t, 10000, atomic.LoadInt64(&total))
+	for _, k := range keys {
+		n, _ := visits.Get(k)
+		require.EqualValues(t, 1, *n, "key %s", k)
+	}
+}
+
+func TestForEachParallelPanics(t *testing.T) {
+	m := New[string, int](4)
+	m.Set("a", 1)
+	// The panic reaches the caller, after every shard was released.
+	require.PanicsWithValue(t, "fn", func() {
+		m.ForEachParallel(func(string, int) { panic("fn") })
+	})
+	m.Set("a", 2)
+	v, _ := m.Get("a")
+	require.Equal(t, 2, v)
+}
+
+func TestAnyAll(t *testing.T) {
+	m := New[string, int](10)
+	for i := 0; i < 1000; i++ {
+		m.Set(fmt.Sprintf("k%d", i), i)
+	}
+
+	// Matches some.
+	require.True(t, m.Any(func(_ string, v int) bool { return v == 500 }))
+	require.False(t, m.All(func(_ string, v int) bool { return v < 500 }))
+	// Matches all.
+	require.True(t, m.Any(func(_ string, v int) bool { return v >= 0 }))
+	require.True(t, m.All(func(_ string, v int) bool { return v >= 0 }))
+	// Matches none.
+	require.False(t, m.Any(func(_ string, v int) bool { return v < 0 }))
+	require.False(t, m.All(func(_ string, v int) bool { return v < 0 }))
+
+	// Any stops early.
+	var calls int64
+	require.True(t, m.Any(func(string, int) bool {
+		atomic.AddInt64(&calls, 1)
+		return true
+	}))
+	require.Less(t, atomic.LoadInt64(&calls), int64(1000))
+
+	empty := New[string, int](10)
+	require.False(t, empty.Any(func(string, int) bool { return true }))
+	require.True(t, empty.All(func(string, int) bool { return false }))
+}
+
 func BenchmarkSet(b *testing.B) {
 	l := len(keyPrefixes)
 	for i := 0; i < b.N; i++ {
@@ -87,6 +371,29 @@ func BenchmarkSet(b *testing.B) {
 	}
 }
 
+// BenchmarkSetParallel compares concurrent inserts with only per-shard
+// counts against also maintaining a global atomic count (as WithMaxTotal
+// does, here with a cap that is never reached).
+func BenchmarkSetParallel(b *testing.B) {
+	bench := func(b *testing.B, opts ...Option[int, int]) {
+		m := New(64, opts...)
+		var worker int64
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			// Disjoint key ranges per goroutine, so the keys themselves
+			// need no shared counter; 2^24 keys each also fit a 32-bit int.
+			key := int(atomic.AddInt64(&worker, 1)) << 24
+			for pb.Next() {
+				key++
+				m.Set(key, 0)
+			}
+		})
+	}
+
+	b.Run("per_shard", func(b *testing.B) { bench(b) })
+	b.Run("global", func(b *testing.B) { bench(b, WithMaxTotal[int, int](math.MaxInt32)) })
+}
+
 func BenchmarkGet(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		bm.Get(keys[i%100000])
@@ -99,12 +406,106 @@ func BenchmarkKeys(b *testing.B) {
 	}
 }
 
+func BenchmarkKeysManyShards(b *testing.B) {
+	const shards = 2000
+	lkeys := make([]string, 100000)
+	for i := range lkeys {
+		lkeys[i] = fmt.Sprintf("key_%d", i)
+	}
+	for _, bc := range []struct {
+		name  string
+		limit int
+	}{
+		// A limit of at least the shard count is one goroutine per shard.
+		{"unbounded", shards},
+		{"bounded", 0},
+	} {
+		m := New[string, int](shards, WithFanOutLimit[string, int](bc.limit))
+		for i, k := range lkeys {
+			m.Set(k, i)
+		}
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				m.Keys()
+			}
+		})
+	}
+}
+
 func BenchmarkCount(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		bm.Count()
 	}
 }
 
+type largeValue struct {
+	payload [4096]byte
+}
+
+func BenchmarkHasLargeValue(b *testing.B) {
+	m := New[string, largeValue](10)
+	lkeys := make([]string, 1000)
+	for i := range lkeys {
+		lkeys[i] = fmt.Sprintf("key_%d", i)
+		m.Set(lkeys[i], largeValue{})
+	}
+	hasViaGet := func(key string) bool {
+		_, ok := m.Get(key)
+		return ok
+	}
+
+	b.Run("get", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			hasViaGet(lkeys[i%len(lkeys)])
+		}
+	})
+	b.Run("direct", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			m.Has(lkeys[i%len(lkeys)])
+		}
+	})
+}
+
+func TestFanOutLimit(t *testing.T) {
+	m := New[int, int](2000, WithFanOutLimit[int, int](3))
+	want := make([]int, 10000)
+	for i := range want {
+		want[i] = i
+		m.Set(i, i)
+	}
+	require.ElementsMatch(t, want, m.Keys())
+	require.ElementsMatch(t, want, m.Values())
+	require.Len(t, m.Items(), len(want))
+
+	var active, peak int32
+	m.ForEachParallel(func(int, int) {
+		n := atomic.AddInt32(&active, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		atomic.AddInt32(&active, -1)
+	})
+	require.LessOrEqual(t, peak, int32(3))
+}
+
+func TestKeyValueType(t *testing.T) {
+	m := New[string, int](2)
+	require.Equal(t, reflect.TypeOf(""), m.KeyType())
+	require.Equal(t, reflect.TypeOf(0), m.ValueType())
+
+	type typed interface {
+		KeyType() reflect.Type
+		ValueType() reflect.Type
+	}
+	var anyMap typed = New[int, any](2)
+	require.Equal(t, reflect.Int, anyMap.KeyType().Kind())
+	require.Equal(t, reflect.Interface, anyMap.ValueType().Kind())
+}
+
 func TestMain(m *testing.M) {
 	rand.Seed(42)
 	bm = New[string, string](10)
@@ -0,0 +1,79 @@
+// Package dmapprom exports the statistics of a dmap.DMap as Prometheus
+// metrics. It is a module of its own, so that dmap itself does not
+// depend on Prometheus.
+package dmapprom
+
+import (
+	"github.com/althk/dmap"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are the Prometheus metrics of one map, read from it live each
+// time they are collected. A Metrics is a prometheus.Collector of all of
+// them.
+type Metrics struct {
+	// Entries is the number of entries in the map (see DMap.Count).
+	Entries prometheus.GaugeFunc
+	// Skew is the shard skew of the map (see dmap.MapStats).
+	Skew prometheus.GaugeFunc
+	// Hits and Misses count the lookups made by Get, WithHitStats.
+	// DMap.ResetStats resets them, which Prometheus treats like a
+	// process restart.
+	Hits   prometheus.CounterFunc
+	Misses prometheus.CounterFunc
+	// MemoryBytes is the estimated size of the map's values
+	// WithMemoryBudget, and 0 without it.
+	MemoryBytes prometheus.GaugeFunc
+}
+
+// NewMetrics returns the metrics of m, named with the prefix name, e.g.
+// name_entries.
+func NewMetrics[K comparable, V any](m dmap.DMap[K, V], name string) *Metrics {
+	gauge := func(suffix, help string, fn func() float64) prometheus.GaugeFunc {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: name + suffix, Help: help}, fn)
+	}
+	counter := func(suffix, help string, fn func() float64) prometheus.CounterFunc {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{Name: name + suffix, Help: help}, fn)
+	}
+	return &Metrics{
+		Entries: gauge("_entries", "Number of entries in the map.",
+			func() float64 { return float64(m.Count()) }),
+		Skew: gauge("_shard_skew", "Entries in the fullest shard over the mean per shard.",
+			func() float64 { return m.Stats().Skew }),
+		Hits: counter("_hits_total", "Lookups that found their key.",
+			func() float64 { return float64(m.Stats().Hits) }),
+		Misses: counter("_misses_total", "Lookups that did not find their key.",
+			func() float64 { return float64(m.Stats().Misses) }),
+		MemoryBytes: gauge("_memory_bytes", "Estimated size of the map's values.",
+			func() float64 { return float64(m.MemoryUsage()) }),
+	}
+}
+
+func (mt *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{mt.Entries, mt.Skew, mt.Hits, mt.Misses, mt.MemoryBytes}
+}
+
+// Describe implements prometheus.Collector.
+func (mt *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range mt.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (mt *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range mt.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// WithMetricsRegistry registers the metrics of the map with reg, named
+// with the prefix name (see NewMetrics), as soon as the map is built, so
+// observability is wired at construction time rather than by a separate
+// call that might be forgotten. It panics like reg.MustRegister, e.g. if
+// another map was registered under the same name.
+func WithMetricsRegistry[K comparable, V any](reg prometheus.Registerer, name string) dmap.Option[K, V] {
+	return dmap.WithOnNew(func(m dmap.DMap[K, V]) {
+		reg.MustRegister(NewMetrics(m, name))
+	})
+}
@@ -0,0 +1,45 @@
+package dmapprom
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/althk/dmap"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithMetricsRegistry(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := dmap.New[string, int](10,
+		dmap.WithHitStats[string, int](),
+		WithMetricsRegistry[string, int](reg, "sessions"))
+	for i := 0; i < 100; i++ {
+		m.Set(fmt.Sprintf("key_%d", i), i)
+	}
+	m.Get("key_0")
+	m.Get("missing")
+
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP sessions_entries Number of entries in the map.
+# TYPE sessions_entries gauge
+sessions_entries 100
+# HELP sessions_hits_total Lookups that found their key.
+# TYPE sessions_hits_total counter
+sessions_hits_total 1
+# HELP sessions_misses_total Lookups that did not find their key.
+# TYPE sessions_misses_total counter
+sessions_misses_total 1
+`), "sessions_entries", "sessions_hits_total", "sessions_misses_total"))
+
+	metrics := NewMetrics(m, "other")
+	require.Equal(t, 100.0, testutil.ToFloat64(metrics.Entries))
+	m.Remove("key_0")
+	require.Equal(t, 99.0, testutil.ToFloat64(metrics.Entries), "read live")
+
+	require.Panics(t, func() {
+		dmap.New[string, int](4, WithMetricsRegistry[string, int](reg, "sessions"))
+	}, "names are unique per registry")
+}
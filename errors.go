@@ -0,0 +1,38 @@
+package dmap
+
+import "errors"
+
+// ErrKeyNotFound reports that a key is not present.
+// Loaders return it (or an error wrapping it) to signal that a key
+// does not exist upstream.
+var ErrKeyNotFound = errors.New("dmap: key not found")
+
+// ErrInvalidShardCount reports an attempt to build a map with fewer
+// than one shard.
+var ErrInvalidShardCount = errors.New("dmap: shard count must be at least 1")
+
+// ErrInvalidShardWeights reports shard weights that do not match the
+// shard count, or are negative or all zero (see WithShardWeights).
+var ErrInvalidShardWeights = errors.New("dmap: invalid shard weights")
+
+// ErrFrozen is the panic value of writes to a frozen map (see Freeze).
+var ErrFrozen = errors.New("dmap: write to frozen map")
+
+// ErrClosed is returned by the error-returning writes to a closed map,
+// such as SetValidated and RemoveE; other writes are dropped (see Close).
+var ErrClosed = errors.New("dmap: write to closed map")
+
+// ErrInvalidLimit is the panic value of Scan with a limit below 1.
+var ErrInvalidLimit = errors.New("dmap: scan limit must be at least 1")
+
+// ErrNotVersioned is the panic value of SetIfVersion on a map built
+// without WithVersioning.
+var ErrNotVersioned = errors.New("dmap: map is not versioned")
+
+// ErrCallbackPanicked reports that a callback panicked, and that the
+// panic was passed to the WithRecover handler.
+var ErrCallbackPanicked = errors.New("dmap: callback panicked")
+
+// ErrCorruptCompact reports data that is not in the compact format
+// (see MarshalCompact).
+var ErrCorruptCompact = errors.New("dmap: corrupt compact data")
@@ -0,0 +1,35 @@
+package dmap
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveE(t *testing.T) {
+	m := New[string, int](4)
+	m.Set("a", 1)
+
+	require.NoError(t, m.RemoveE("a"))
+	require.False(t, m.Has("a"))
+	require.EqualValues(t, 0, m.Count())
+
+	err := m.RemoveE("a")
+	require.ErrorIs(t, err, ErrKeyNotFound)
+	require.True(t, errors.Is(fmt.Errorf("wrapped: %w", err), ErrKeyNotFound))
+}
+
+func TestNewE(t *testing.T) {
+	for _, n := range []int{0, -1} {
+		m, err := NewE[string, int](n)
+		require.Nil(t, m)
+		require.True(t, errors.Is(err, ErrInvalidShardCount))
+		require.PanicsWithValue(t, ErrInvalidShardCount, func() { New[string, int](n) })
+	}
+
+	m, err := NewE[string, int](3)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(m))
+}
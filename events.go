@@ -0,0 +1,130 @@
+package dmap
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// EventKind is the kind of change an Event reports.
+type EventKind int
+
+const (
+	// EventSet reports that Value was stored for Key.
+	EventSet EventKind = iota
+	// EventDelete reports that Key, holding Value, was removed.
+	EventDelete
+	// EventTombstone reports that Key, holding Value (the zero value if
+	// it was absent), was removed with RemoveWithTombstone.
+	EventTombstone
+)
+
+// Event is a change to a DMap, as delivered to subscribers.
+type Event[K comparable, V any] struct {
+	Kind  EventKind
+	Key   K
+	Value V
+}
+
+// Subscribe returns a channel that receives an Event for every change
+// to a single key: stores (Set, Compute, SetMany, ...) and removals
+// (Remove, DeleteFunc, evictions, ...). Bulk resets (Clear, ReplaceAll)
+// are not reported. Events for one key arrive in the order of its
+// changes.
+//
+// Events are sent while the writer holds its shard lock, so they are
+// never waited for: when the channel's buffer is full, events are
+// dropped. Size buffer for the expected write rate. WithEventBuffer,
+// events are delivered in batches instead.
+// cancel ends the subscription and closes the channel; Close ends all
+// subscriptions.
+func (m DMap[K, V]) Subscribe(buffer int) (events <-chan Event[K, V], cancel func()) {
+	subs := m.state().subs
+	ch := make(chan Event[K, V], buffer)
+	subs.mu.Lock()
+	if subs.chans == nil {
+		subs.chans = make(map[chan Event[K, V]]struct{})
+	}
+	subs.chans[ch] = struct{}{}
+	atomic.AddInt32(&subs.count, 1)
+	subs.mu.Unlock()
+	return ch, func() { subs.unsubscribe(ch) }
+}
+
+// subscriptions holds the channels handed out by Subscribe. It is kept
+// apart from the rest of the map's state so that a map taking the place
+// of another one, as when a Resizable grows, can take over its
+// subscriptions.
+type subscriptions[K comparable, V any] struct {
+	// count is the number of channels in chans.
+	count int32
+	mu    sync.RWMutex
+	chans map[chan Event[K, V]]struct{}
+}
+
+func (s *subscriptions[K, V]) unsubscribe(ch chan Event[K, V]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.chans[ch]; !ok {
+		return
+	}
+	delete(s.chans, ch)
+	atomic.AddInt32(&s.count, -1)
+	close(ch)
+}
+
+// cancelSubscriptions ends every subscription.
+func (s *state[K, V]) cancelSubscriptions() {
+	subs := s.subs
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+	for ch := range subs.chans {
+		close(ch)
+	}
+	subs.chans = nil
+	atomic.StoreInt32(&subs.count, 0)
+}
+
+// publish reports a change to the shard to subscribers; WithEventBuffer
+// it queues the event for the next flushEvents.
+// The caller must hold the shard's write lock.
+func (s *Shard[K, V]) publish(kind EventKind, key K, val V) {
+	if atomic.LoadInt32(&s.state.subs.count) == 0 {
+		return
+	}
+	e := Event[K, V]{Kind: kind, Key: key, Value: val}
+	if s.cfg.eventFlushEvery > 0 {
+		s.events = append(s.events, e)
+		return
+	}
+	s.state.publish(e)
+}
+
+// publish sends events to every subscriber that has room for them.
+func (s *state[K, V]) publish(events ...Event[K, V]) {
+	subs := s.subs
+	subs.mu.RLock()
+	defer subs.mu.RUnlock()
+	for ch := range subs.chans {
+		for _, e := range events {
+			select {
+			case ch <- e:
+			default:
+			}
+		}
+	}
+}
+
+// flushEvents delivers the events buffered WithEventBuffer, one shard's
+// batch at a time.
+func (m DMap[K, V]) flushEvents() {
+	st := m.state()
+	for _, shard := range m {
+		shard.mu.Lock()
+		events := shard.events
+		shard.events = nil
+		shard.mu.Unlock()
+		if len(events) > 0 {
+			st.publish(events...)
+		}
+	}
+}
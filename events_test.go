@@ -0,0 +1,100 @@
+package dmap
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribe(t *testing.T) {
+	m := New[string, int](4)
+	events, cancel := m.Subscribe(10)
+
+	m.Set("a", 1)
+	m.Set("a", 2)
+	m.Remove("a")
+	m.Remove("missing")
+
+	require.Equal(t, Event[string, int]{EventSet, "a", 1}, <-events)
+	require.Equal(t, Event[string, int]{EventSet, "a", 2}, <-events)
+	require.Equal(t, Event[string, int]{EventDelete, "a", 2}, <-events)
+	require.Empty(t, events)
+
+	cancel()
+	cancel()
+	_, open := <-events
+	require.False(t, open)
+	m.Set("b", 1) // no subscribers left
+}
+
+func TestSubscribeDropsWhenFull(t *testing.T) {
+	m := New[int, int](4)
+	events, cancel := m.Subscribe(2)
+	defer cancel()
+	for i := 0; i < 5; i++ {
+		m.Set(i, i)
+	}
+	require.Len(t, events, 2)
+}
+
+func TestSubscribeClosedByClose(t *testing.T) {
+	m := New[int, int](4)
+	events, cancel := m.Subscribe(1)
+	require.NoError(t, m.Close())
+	_, open := <-events
+	require.False(t, open)
+	cancel()
+}
+
+func TestWithEventBuffer(t *testing.T) {
+	m := New[int, int](8, WithEventBuffer[int, int](time.Millisecond))
+	defer m.Close()
+	const writers, perWriter = 8, 1000
+	events, cancel := m.Subscribe(writers * perWriter * 2)
+	defer cancel()
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				key := w*perWriter + i
+				m.Set(key, 1)
+				m.Set(key, 2)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	last := map[int]int{}
+	timeout := time.After(5 * time.Second)
+	for n := 0; n < writers*perWriter*2; n++ {
+		select {
+		case e := <-events:
+			require.Equal(t, EventSet, e.Kind)
+			require.Equal(t, last[e.Key]+1, e.Value, "events for a key stay in order")
+			last[e.Key] = e.Value
+		case <-timeout:
+			t.Fatalf("only %d events delivered", n)
+		}
+	}
+	require.Len(t, last, writers*perWriter)
+}
+
+func TestWithEventBufferDeliveredOnClose(t *testing.T) {
+	m := New[string, int](4, WithEventBuffer[string, int](time.Hour))
+	events, _ := m.Subscribe(10)
+	m.Set("a", 1)
+	m.Remove("a")
+	require.Empty(t, events, "buffered until the next flush")
+
+	require.NoError(t, m.Close())
+	var got []Event[string, int]
+	for e := range events {
+		got = append(got, e)
+	}
+	require.Equal(t, []Event[string, int]{{EventSet, "a", 1}, {EventDelete, "a", 1}}, got)
+}
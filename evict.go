@@ -0,0 +1,71 @@
+package dmap
+
+import "sync/atomic"
+
+// evictOverflow removes entries until the map is back within its
+// WithMemoryBudget and WithMaxTotal cap, never choosing one of keep as a
+// victim. Over the cap, victims come from the fullest shard at the time
+// of each eviction (over budget, see evictOverBudget).
+// Finding and locking that shard needs cross-shard coordination, so it
+// runs after the inserting write has released its own shard lock: under
+// concurrent inserts the total may briefly exceed the cap, and an insert
+// may evict from any shard, not just its own. For the same reason it
+// first drops the copies a write left behind when moving a routed key
+// (see dropDisplaced).
+func (m DMap[K, V]) evictOverflow(keep ...K) {
+	m.dropDisplaced()
+	m.evictOverBudget(keep)
+	max := m.config().maxTotal
+	if max <= 0 {
+		return
+	}
+	st := m.state()
+	for atomic.LoadInt64(&st.total) > max {
+		if !m.fullestShard().evictOne(keep) {
+			return
+		}
+	}
+}
+
+// reserve counts a new entry in the map's total, WithMaxTotal.
+// WithRejectOnFull, it reports false, counting nothing, if the map is full.
+func (s *Shard[K, V]) reserve() bool {
+	n := atomic.AddInt64(&s.state.total, 1)
+	if s.cfg.rejectOnFull && n > s.cfg.maxTotal {
+		atomic.AddInt64(&s.state.total, -1)
+		return false
+	}
+	return true
+}
+
+func (m DMap[K, V]) fullestShard() *Shard[K, V] {
+	fullest, most := m[0], int64(-1)
+	for _, shard := range m {
+		shard.mu.RLock()
+		n := shard.count
+		shard.mu.RUnlock()
+		if n > most {
+			fullest, most = shard, n
+		}
+	}
+	return fullest
+}
+
+// evictOne removes an arbitrary entry not in keep, reporting whether
+// one was found.
+func (s *Shard[K, V]) evictOne(keep []K) bool {
+	if !s.lockWrite() {
+		return false
+	}
+	defer s.mu.Unlock()
+next:
+	for k := range s.items {
+		for _, kk := range keep {
+			if k == kk {
+				continue next
+			}
+		}
+		return s.delete(k)
+	}
+	return false
+}
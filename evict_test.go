@@ -0,0 +1,124 @@
+package dmap
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithMaxTotal(t *testing.T) {
+	m := New[string, int](8, WithMaxTotal[string, int](100))
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key_%d", i)
+		m.Set(key, i)
+		require.LessOrEqual(t, m.Count(), int64(100))
+		require.True(t, m.Has(key), "the inserted key must survive")
+	}
+	require.EqualValues(t, 100, m.Count())
+
+	// Overwrites do not evict.
+	for _, k := range m.Keys() {
+		m.Set(k, -1)
+	}
+	require.EqualValues(t, 100, m.Count())
+
+	m.SetMany(map[string]int{"a": 1, "b": 2, "c": 3})
+	require.EqualValues(t, 100, m.Count())
+	m.Compute("d", func(int, bool) int { return 4 })
+	require.EqualValues(t, 100, m.Count())
+	require.True(t, m.Has("d"))
+}
+
+func TestWithMaxTotalConcurrent(t *testing.T) {
+	m := New[string, int](8, WithMaxTotal[string, int](50))
+	wg := sync.WaitGroup{}
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				m.Set(fmt.Sprintf("key_%d_%d", w, i), i)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	require.EqualValues(t, 50, m.Count())
+	require.EqualValues(t, 50, m.state().total)
+}
+
+func TestWithRejectOnFull(t *testing.T) {
+	var rejected []string
+	m := New[string, int](4,
+		WithMaxTotal[string, int](3),
+		WithRejectOnFull(func(k string, _ int) { rejected = append(rejected, k) }))
+	for _, k := range []string{"a", "b", "c"} {
+		require.True(t, m.TrySet(k, 1))
+	}
+
+	require.False(t, m.TrySet("d", 1))
+	m.Set("e", 1)
+	m.Compute("f", func(int, bool) int { return 1 })
+	require.Equal(t, []string{"d", "e", "f"}, rejected)
+	require.EqualValues(t, 3, m.Count())
+	require.False(t, m.Has("d"))
+
+	// Updates of present keys still succeed.
+	require.True(t, m.TrySet("a", 2))
+	m.Set("b", 2)
+	require.Equal(t, map[string]int{"a": 2, "b": 2, "c": 1}, m.Items())
+
+	m.Remove("a")
+	require.True(t, m.TrySet("d", 1))
+	require.Len(t, rejected, 3)
+}
+
+func TestWithRejectOnFullReportsRejection(t *testing.T) {
+	clock := newFakeClock()
+	m := New[string, int](4,
+		WithMaxTotal[string, int](1),
+		WithRejectOnFull(func(string, int) {}),
+		WithVersioning[string, int](),
+		WithClock[string, int](clock))
+	m.Set("a", 1)
+
+	require.False(t, m.SetIfAbsent("b", 1))
+	require.False(t, m.SetNX("b", 1))
+	version, ok := m.SetIfVersion("b", 1, 0)
+	require.False(t, ok)
+	require.Zero(t, version)
+	require.False(t, Mutate(m, "b", func(int, bool) (int, bool) { return 1, true }))
+	require.Equal(t, map[string]int{"a": 1}, m.Items())
+
+	// A rejected SetWithTTL leaves no expiry behind.
+	m.SetWithTTL("b", 1, time.Minute)
+	require.False(t, m.Has("b"))
+	require.EqualValues(t, 1, m.CountLive())
+	clock.Advance(time.Hour)
+	require.EqualValues(t, 1, m.CountLive())
+}
+
+func TestWithRejectOnFullConcurrent(t *testing.T) {
+	var rejected int64
+	m := New[string, int](8,
+		WithMaxTotal[string, int](50),
+		WithRejectOnFull(func(string, int) { atomic.AddInt64(&rejected, 1) }))
+	wg := sync.WaitGroup{}
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				m.Set(fmt.Sprintf("key_%d_%d", w, i), i)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	require.EqualValues(t, 50, m.Count())
+	require.EqualValues(t, 8*200-50, rejected)
+}
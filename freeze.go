@@ -0,0 +1,88 @@
+package dmap
+
+import "sync/atomic"
+
+// Freeze makes the map permanently read-only, e.g. once a config cache
+// has been loaded at startup. Any write after Freeze panics with ErrFrozen.
+// Since no writer can exist any more, Get and Has on a frozen map skip
+// locking entirely; other reads still take (uncontended) read locks.
+// Freeze waits for in-flight writes to finish. Calling it again is a no-op.
+func (m DMap[K, V]) Freeze() {
+	st := m.state()
+	if st.isFrozen() {
+		return
+	}
+	// A frozen map can no longer drop the copies moved keys left behind.
+	m.dropDisplaced()
+	indices := make([]int, len(m))
+	for i := range m {
+		indices[i] = i
+	}
+	// Writers check the flag while holding their shard's lock, so once
+	// every lock has been held here no write can be in progress or start.
+	locks := m.lockShards(true, indices...)
+	atomic.StoreInt32(&st.frozen, 1)
+	unlockShards(true, locks)
+}
+
+// IsFrozen reports whether Freeze has been called on the map.
+func (m DMap[K, V]) IsFrozen() bool {
+	return m.state().isFrozen()
+}
+
+func (s *state[K, V]) isFrozen() bool {
+	return atomic.LoadInt32(&s.frozen) == 1
+}
+
+// writeErr returns why the map cannot be written: ErrFrozen if it is
+// frozen, ErrClosed if it is closed, or nil if it can be written.
+func (s *state[K, V]) writeErr() error {
+	if s.isFrozen() {
+		return ErrFrozen
+	}
+	if s.isClosed() {
+		return ErrClosed
+	}
+	return nil
+}
+
+// locked runs fn under the shard's write lock, released even if fn (or a
+// callback it runs) panics, and reports whether fn ran: false if the map
+// is closed. Work such as evictOverflow, which takes other locks, belongs
+// after it returns.
+func (s *Shard[K, V]) locked(fn func()) bool {
+	if !s.lockWrite() {
+		return false
+	}
+	defer s.mu.Unlock()
+	fn()
+	return true
+}
+
+// canWrite reports whether the map can be written, or false if it is
+// closed, for the caller to drop the write. It panics with ErrFrozen if
+// the map is frozen. The caller must hold the write locks of the shards
+// it writes, released by a deferred unlock.
+func (s *state[K, V]) canWrite() bool {
+	err := s.writeErr()
+	if err == ErrFrozen {
+		panic(err)
+	}
+	return err == nil
+}
+
+// lockWrite write-locks the shard and reports true, or, if the map is
+// closed, reports false with the lock released, for the caller to drop
+// the write. It panics with ErrFrozen (leaving the lock released) if the
+// map is frozen.
+func (s *Shard[K, V]) lockWrite() bool {
+	s.mu.Lock()
+	if err := s.state.writeErr(); err != nil {
+		s.mu.Unlock()
+		if err == ErrFrozen {
+			panic(err)
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package dmap
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFreeze(t *testing.T) {
+	m := New[string, string](10)
+	prepareTestData(m, 1000, "some val")
+	require.False(t, m.IsFrozen())
+
+	m.Freeze()
+	m.Freeze()
+	require.True(t, m.IsFrozen())
+
+	// Reads still work, and Get/Has must not need the lock at all.
+	for _, shard := range m {
+		shard.mu.Lock()
+	}
+	v, ok := m.Get(keys[0])
+	require.True(t, ok)
+	require.Equal(t, "some val", v)
+	require.True(t, m.Has(keys[1]))
+	require.False(t, m.Has("nonexistentkey"))
+	for _, shard := range m {
+		shard.mu.Unlock()
+	}
+	require.EqualValues(t, 1000, m.Count())
+	require.ElementsMatch(t, keys, m.Keys())
+
+	require.PanicsWithValue(t, ErrFrozen, func() { m.Set("foo", "bar") })
+	require.PanicsWithValue(t, ErrFrozen, func() { m.Remove(keys[0]) })
+	require.PanicsWithValue(t, ErrFrozen, func() { m.Clear() })
+	require.PanicsWithValue(t, ErrFrozen, func() {
+		m.SetMany(map[string]string{"foo": "bar"})
+	})
+	require.PanicsWithValue(t, ErrFrozen, func() {
+		m.AtomicSetAll(map[string]string{"foo": "bar"})
+	})
+
+	// Failed writes leave the map untouched and unlocked.
+	require.False(t, m.Has("foo"))
+	require.EqualValues(t, 1000, m.Count())
+	for _, shard := range m {
+		shard.mu.Lock()
+		shard.mu.Unlock()
+	}
+}
+
+func BenchmarkGetFrozen(b *testing.B) {
+	m := New[string, string](10)
+	lkeys := make([]string, 1000)
+	for i := range lkeys {
+		lkeys[i] = fmt.Sprintf("key_%d", i)
+		m.Set(lkeys[i], "some val")
+	}
+
+	get := func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				m.Get(lkeys[i%len(lkeys)])
+			}
+		})
+	}
+
+	b.Run("normal", get)
+	m.Freeze()
+	b.Run("frozen", get)
+}
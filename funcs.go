@@ -0,0 +1,80 @@
+package dmap
+
+// GroupBy partitions items into a new DMap with nShards shards, keyed by
+// keyFn. Each value holds the items sharing that key, in input order.
+func GroupBy[T any, K comparable](items []T, keyFn func(T) K, nShards int) DMap[K, []T] {
+	m := New[K, []T](nShards)
+	for _, item := range items {
+		item := item
+		m.Compute(keyFn(item), func(group []T, _ bool) []T {
+			return append(group, item)
+		})
+	}
+	return m
+}
+
+// Append atomically appends elems to the slice stored at key, creating
+// it if key is absent.
+func Append[K comparable, E any](m DMap[K, []E], key K, elems ...E) {
+	m.Compute(key, func(old []E, _ bool) []E {
+		return append(old, elems...)
+	})
+}
+
+// TransformKeys returns a new DMap with nShards shards holding the
+// entries of m under the keys fn(key), e.g. to migrate a key encoding.
+// If fn maps several keys to the same new key, one of their values is
+// kept, unspecified which; use TransformKeysFunc to choose.
+func TransformKeys[K1, K2 comparable, V any](m DMap[K1, V], fn func(K1) K2, nShards int) DMap[K2, V] {
+	return TransformKeysFunc(m, fn, func(_ K2, kept, _ V) V { return kept }, nShards)
+}
+
+// TransformKeysFunc is like TransformKeys, but resolves collisions with
+// resolve: when an entry maps to a key already filled in, the new map
+// stores resolve(key, existing, value). resolve must not access the maps.
+func TransformKeysFunc[K1, K2 comparable, V any](m DMap[K1, V], fn func(K1) K2, resolve func(key K2, existing, value V) V, nShards int) DMap[K2, V] {
+	out := New[K2, V](nShards)
+	m.ForEach(func(k K1, v V) bool {
+		key := fn(k)
+		out.Compute(key, func(existing V, exists bool) V {
+			if exists {
+				return resolve(key, existing, v)
+			}
+			return v
+		})
+		return true
+	})
+	return out
+}
+
+// MapReduce runs mapFn over every entry of m and returns reduceFn of the
+// results. Shards are mapped concurrently (see WithFanOutLimit), each
+// under its read lock, so mapFn must be safe for concurrent use and must
+// not modify m. The results are passed to reduceFn grouped by ascending
+// shard index, but in no particular order within a shard, so reduceFn
+// should not depend on their order (a sum or a count, say) unless it
+// sorts them itself. Values are copied WithCopyOnRead.
+func MapReduce[K comparable, V any, M any, R any](m DMap[K, V], mapFn func(K, V) M, reduceFn func([]M) R) R {
+	perShard := make([][]M, len(m))
+	index := make(map[*Shard[K, V]]int, len(m))
+	for i, shard := range m {
+		index[shard] = i
+	}
+	m.fanOut(func(shard *Shard[K, V]) {
+		var mapped []M
+		shard.forEach(func(k K, v V) bool {
+			mapped = append(mapped, mapFn(k, shard.readCopy(v)))
+			return true
+		})
+		perShard[index[shard]] = mapped
+	})
+	n := 0
+	for _, mapped := range perShard {
+		n += len(mapped)
+	}
+	all := make([]M, 0, n)
+	for _, mapped := range perShard {
+		all = append(all, mapped...)
+	}
+	return reduceFn(all)
+}
@@ -0,0 +1,140 @@
+package dmap
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type record struct {
+	name string
+	team string
+}
+
+func TestGroupBy(t *testing.T) {
+	records := []record{
+		{"alice", "red"},
+		{"bob", "blue"},
+		{"carol", "red"},
+		{"dave", "green"},
+		{"erin", "blue"},
+		{"frank", "red"},
+	}
+
+	m := GroupBy(records, func(r record) string { return r.team }, 4)
+	require.Equal(t, 4, len(m))
+	require.EqualValues(t, 3, m.Count())
+
+	red, ok := m.Get("red")
+	require.True(t, ok)
+	require.Equal(t, []record{records[0], records[2], records[5]}, red)
+
+	blue, _ := m.Get("blue")
+	require.Equal(t, []record{records[1], records[4]}, blue)
+
+	green, _ := m.Get("green")
+	require.Equal(t, []record{records[3]}, green)
+}
+
+func TestAppend(t *testing.T) {
+	m := New[string, []int](4)
+	Append(m, "a", 1, 2)
+	Append(m, "a", 3)
+	Append(m, "b")
+
+	a, _ := m.Get("a")
+	require.Equal(t, []int{1, 2, 3}, a)
+	require.True(t, m.Has("b"))
+	require.EqualValues(t, 2, m.Count())
+}
+
+func TestAppendConcurrent(t *testing.T) {
+	m := New[string, []int](4)
+	wg := sync.WaitGroup{}
+	for w := 0; w < 20; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				Append(m, "k", w, i)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	v, _ := m.Get("k")
+	require.Len(t, v, 20*100*2)
+	require.EqualValues(t, 1, m.Count())
+}
+
+func TestTransformKeys(t *testing.T) {
+	m := New[int, string](4)
+	for i := 0; i < 100; i++ {
+		m.Set(i, fmt.Sprint("v", i))
+	}
+
+	out := TransformKeys(m, func(k int) string { return fmt.Sprintf("user:%03d", k) }, 8)
+	require.Equal(t, 8, len(out))
+	require.EqualValues(t, 100, out.Count())
+	for i := 0; i < 100; i++ {
+		v, ok := out.Get(fmt.Sprintf("user:%03d", i))
+		require.True(t, ok)
+		require.Equal(t, fmt.Sprint("v", i), v)
+	}
+}
+
+func TestTransformKeysFunc(t *testing.T) {
+	m := New[string, int](4)
+	m.Set("Alice", 1)
+	m.Set("alice", 2)
+	m.Set("ALICE", 4)
+	m.Set("bob", 8)
+
+	sum := func(_ string, existing, value int) int { return existing + value }
+	out := TransformKeysFunc(m, strings.ToLower, sum, 2)
+	require.Equal(t, map[string]int{"alice": 7, "bob": 8}, out.Items())
+
+	kept := TransformKeys(m, strings.ToLower, 2)
+	v, _ := kept.Get("alice")
+	require.Contains(t, []int{1, 2, 4}, v)
+}
+
+func TestMapReduce(t *testing.T) {
+	m := New[int, string](10)
+	words := []string{"alpha", "beta", "gamma", "beta", "alpha", "alpha"}
+	for i := 0; i < 1000; i++ {
+		m.Set(i, strings.Join(words[:i%len(words)+1], " "))
+	}
+
+	countWords := func(_ int, v string) map[string]int {
+		counts := map[string]int{}
+		for _, w := range strings.Fields(v) {
+			counts[w]++
+		}
+		return counts
+	}
+	merge := func(parts []map[string]int) map[string]int {
+		total := map[string]int{}
+		for _, counts := range parts {
+			for w, n := range counts {
+				total[w] += n
+			}
+		}
+		return total
+	}
+	got := MapReduce(m, countWords, merge)
+
+	want := map[string]int{}
+	for _, v := range m.Items() {
+		for _, w := range strings.Fields(v) {
+			want[w]++
+		}
+	}
+	require.Equal(t, want, got)
+
+	n := MapReduce(New[int, string](4), func(int, string) int { return 1 }, func(ones []int) int { return len(ones) })
+	require.Zero(t, n)
+}
@@ -0,0 +1,131 @@
+package dmap
+
+import (
+	"math"
+	"reflect"
+)
+
+// Keys are placed on shards by hashKey(key) mod the shard count, unless
+// a hasher is configured with WithHasher.
+//
+// hashKey is 64-bit FNV-1a over a fixed binary encoding of the key, so
+// placement is stable across runs, platforms and Go versions, and does
+// not depend on how fmt happens to format a key:
+//
+//   - strings are their length (as below) followed by their bytes;
+//   - bools are one byte, 0 or 1;
+//   - integers, and uintptrs, are 8 bytes little-endian (sign-extended);
+//   - floats are the 8 byte IEEE 754 bits of their float64 value, with
+//     -0 folded into +0; complex numbers are their real then imaginary part;
+//   - arrays and structs are their elements or fields, in order;
+//   - interfaces are their dynamic kind followed by their dynamic value
+//     (a nil interface is a single 0 byte);
+//   - pointers, channels and unsafe pointers are their address, so their
+//     placement is only stable within one process.
+//
+// Named types encode like their underlying type.
+func hashKey[K comparable](key K) uint64 {
+	return hashKeySeed(key, 0)
+}
+
+// hashKeySeed is hashKey with seed mixed into the FNV offset basis, so
+// that different seeds give unrelated placements (see WithHashSeed).
+func hashKeySeed[K comparable](key K, seed uint64) uint64 {
+	h := uint64(fnvOffset64) ^ seed
+	switch k := any(key).(type) {
+	case string:
+		return hashString(h, k)
+	case int:
+		return hashUint64(h, uint64(k))
+	case int64:
+		return hashUint64(h, uint64(k))
+	case int32:
+		return hashUint64(h, uint64(k))
+	case uint:
+		return hashUint64(h, uint64(k))
+	case uint64:
+		return hashUint64(h, k)
+	case uint32:
+		return hashUint64(h, uint64(k))
+	}
+	return hashValue(h, reflect.ValueOf(key))
+}
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+func hashByte(h uint64, b byte) uint64 {
+	return (h ^ uint64(b)) * fnvPrime64
+}
+
+func hashUint64(h uint64, v uint64) uint64 {
+	for i := 0; i < 8; i++ {
+		h = hashByte(h, byte(v>>(8*i)))
+	}
+	return h
+}
+
+func hashString(h uint64, s string) uint64 {
+	h = hashUint64(h, uint64(len(s)))
+	for i := 0; i < len(s); i++ {
+		h = hashByte(h, s[i])
+	}
+	return h
+}
+
+func hashBytes(h uint64, b []byte) uint64 {
+	for _, c := range b {
+		h = hashByte(h, c)
+	}
+	return h
+}
+
+func hashFloat(h uint64, f float64) uint64 {
+	if f == 0 {
+		f = 0 // -0 == +0, so they must hash alike
+	}
+	return hashUint64(h, math.Float64bits(f))
+}
+
+func hashValue(h uint64, v reflect.Value) uint64 {
+	switch v.Kind() {
+	case reflect.Invalid: // a nil interface key, e.g. of a DMap[any, V]
+		return hashByte(h, 0)
+	case reflect.String:
+		return hashString(h, v.String())
+	case reflect.Bool:
+		if v.Bool() {
+			return hashByte(h, 1)
+		}
+		return hashByte(h, 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return hashUint64(h, uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return hashUint64(h, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return hashFloat(h, v.Float())
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		return hashFloat(hashFloat(h, real(c)), imag(c))
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			h = hashValue(h, v.Index(i))
+		}
+		return h
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			h = hashValue(h, v.Field(i))
+		}
+		return h
+	case reflect.Interface:
+		if v.IsNil() {
+			return hashByte(h, 0)
+		}
+		elem := v.Elem()
+		return hashValue(hashByte(h, byte(elem.Kind())), elem)
+	default: // pointers, channels, unsafe pointers
+		return hashUint64(h, uint64(v.Pointer()))
+	}
+}
@@ -0,0 +1,154 @@
+package dmap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type pair struct {
+	A, B string
+}
+
+func TestHashKeyDistinctStructs(t *testing.T) {
+	k1 := pair{"a b", "c"}
+	k2 := pair{"a", "b c"}
+	require.Equal(t, fmt.Sprintf("%v", k1), fmt.Sprintf("%v", k2))
+	require.NotEqual(t, hashKey(k1), hashKey(k2))
+
+	m := New[pair, int](16)
+	m.Set(k1, 1)
+	m.Set(k2, 2)
+	v1, _ := m.Get(k1)
+	v2, _ := m.Get(k2)
+	require.Equal(t, 1, v1)
+	require.Equal(t, 2, v2)
+	require.EqualValues(t, 2, m.Count())
+
+	// Placement is a pure function of the key and the shard count.
+	for _, k := range []pair{k1, k2} {
+		i := m.getShardIndex(k)
+		require.Equal(t, int(hashKey(k)%16), i)
+		require.Equal(t, i, New[pair, int](16).getShardIndex(k))
+		require.Contains(t, m[i].items, k)
+	}
+}
+
+func TestHashKeyStable(t *testing.T) {
+	// These values pin the documented encoding; changing them changes
+	// where existing keys are placed.
+	require.EqualValues(t, uint64(0xf788c6d27122af3d), hashKey("key"))
+	require.EqualValues(t, hashKey(int64(42)), hashKey(42))
+	require.EqualValues(t, hashKey(uint64(42)), hashKey(42))
+
+	type id string
+	require.Equal(t, hashKey("key"), hashKey(id("key")))
+	require.Equal(t, hashKey(0.0), hashKey(math.Copysign(0, -1)))
+	require.Equal(t, hashKey([2]int{1, 2}), hashKey(struct{ X, Y int }{1, 2}))
+	require.NotEqual(t, hashKey([2]int{1, 2}), hashKey([2]int{2, 1}))
+	type boxed struct{ V any }
+	hashBoxed := func(v any) uint64 {
+		return hashValue(fnvOffset64, reflect.ValueOf(boxed{v}))
+	}
+	require.NotEqual(t, hashBoxed(1), hashBoxed("1"))
+	require.Equal(t, hashBoxed(1), hashBoxed(1))
+}
+
+type point struct {
+	X, Y int32
+	Tag  string
+}
+
+func encodePoint(p point) []byte {
+	b := make([]byte, 8, 8+len(p.Tag))
+	binary.LittleEndian.PutUint32(b, uint32(p.X))
+	binary.LittleEndian.PutUint32(b[4:], uint32(p.Y))
+	return append(b, p.Tag...)
+}
+
+func TestHashKeyNil(t *testing.T) {
+	type boxed struct{ V any }
+	require.Equal(t, hashKey[any](nil), hashValue(fnvOffset64, reflect.ValueOf(boxed{})))
+
+	m := New[any, int](4)
+	m.Set(nil, 1)
+	m.Set(0, 2)
+	v, ok := m.Get(nil)
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+	require.EqualValues(t, 2, m.Count())
+
+	bloomed := New[any, int](4, WithBloomFilter[any, int](16))
+	bloomed.Set(nil, 1)
+	require.True(t, bloomed.Has(nil))
+
+	errs := New[error, int](4)
+	errs.Set(nil, 1)
+	require.True(t, errs.Has(nil))
+	require.Len(t, errs.KeysStable(), 1)
+	require.NotZero(t, Checksum(errs))
+}
+
+func TestWithKeyEncoder(t *testing.T) {
+	m := New[point, int](16, WithKeyEncoder[point, int](encodePoint))
+	for x := int32(0); x < 10; x++ {
+		for y := int32(0); y < 10; y++ {
+			m.Set(point{x, y, "a"}, int(x*10+y))
+		}
+	}
+	require.EqualValues(t, 100, m.Count())
+	for x := int32(0); x < 10; x++ {
+		for y := int32(0); y < 10; y++ {
+			v, ok := m.Get(point{x, y, "a"})
+			require.True(t, ok)
+			require.Equal(t, int(x*10+y), v)
+		}
+	}
+
+	// Placement follows the encoding, and is pinned across runs.
+	k := point{1, 2, "a"}
+	require.EqualValues(t, uint64(0x6bf7d4318a93d3c5), hashBytes(fnvOffset64, encodePoint(k)))
+	require.Equal(t, int(0x6bf7d4318a93d3c5%16), m.ShardIndex(k))
+	require.Contains(t, m[m.ShardIndex(k)].items, k)
+
+	// WithHashSeed still applies.
+	seeded := New[point, int](16,
+		WithKeyEncoder[point, int](encodePoint), WithHashSeed[point, int](1))
+	require.Equal(t, int(hashBytes(fnvOffset64^1, encodePoint(k))%16), seeded.ShardIndex(k))
+}
+
+func TestDefaultHashSpreadsKeys(t *testing.T) {
+	m := New[string, int](1000)
+	for i := 0; i < 100000; i++ {
+		m.Set(fmt.Sprintf("key_%d", i), i)
+	}
+	for i, shard := range m {
+		require.NotEmpty(t, shard.items, "shard %d", i)
+	}
+}
+
+func TestWithHashSeed(t *testing.T) {
+	m1 := New[string, int](16, WithHashSeed[string, int](1))
+	m2 := New[string, int](16, WithHashSeed[string, int](2))
+
+	moved := 0
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key_%d", i)
+		i1, i2 := m1.getShardIndex(key), m2.getShardIndex(key)
+		if i1 != i2 {
+			moved++
+		}
+		// Placement is stable within one map.
+		require.Equal(t, i1, m1.getShardIndex(key))
+		m1.Set(key, i)
+		require.Contains(t, m1[i1].items, key)
+	}
+	require.Greater(t, moved, 50)
+
+	// Derived maps keep the seed.
+	require.Equal(t, m1.getShardIndex("key_1"), m1.Clone().getShardIndex("key_1"))
+}
@@ -0,0 +1,70 @@
+package dmap
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// MarshalJSON encodes the map as a JSON object, like a Go map of the same
+// key and value types, with keys sorted. Like Items, it copies the whole
+// map out first. For large maps see MarshalJSONParallel.
+func (m DMap[K, V]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.Items())
+}
+
+// MarshalJSONParallel is like MarshalJSON, but encodes the shards
+// concurrently (see WithFanOutLimit) and joins the results, trading
+// memory for speed on large maps: every shard's encoding is buffered
+// before they are concatenated. Keys are sorted within each shard only.
+// Each shard is a consistent snapshot, the map as a whole is not.
+func (m DMap[K, V]) MarshalJSONParallel() ([]byte, error) {
+	parts := make([][]byte, len(m))
+	errs := make([]error, len(m))
+	index := make(map[*Shard[K, V]]int, len(m))
+	for i, shard := range m {
+		index[shard] = i
+	}
+	m.fanOut(func(shard *Shard[K, V]) {
+		i := index[shard]
+		parts[i], errs[i] = json.Marshal(shard.snapshot())
+	})
+	size := 2
+	for i, part := range parts {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		size += len(part)
+	}
+	out := bytes.NewBuffer(make([]byte, 0, size))
+	out.WriteByte('{')
+	first := true
+	for _, part := range parts {
+		// Each part is an object; splice in its members, if any.
+		members := part[1 : len(part)-1]
+		if len(members) == 0 {
+			continue
+		}
+		if !first {
+			out.WriteByte(',')
+		}
+		out.Write(members)
+		first = false
+	}
+	out.WriteByte('}')
+	return out.Bytes(), nil
+}
+
+// snapshot returns a copy of the live entries of the shard, with values
+// copied WithCopyOnRead.
+func (s *Shard[K, V]) snapshot() map[K]V {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := s.now()
+	items := make(map[K]V, len(s.items))
+	for k, v := range s.items {
+		if !s.expiredAt(k, now) {
+			items[k] = s.readCopy(v)
+		}
+	}
+	return items
+}
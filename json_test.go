@@ -0,0 +1,75 @@
+package dmap
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	m := New[string, int](10)
+	want := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		k := fmt.Sprintf("key_%d", i)
+		m.Set(k, i)
+		want[k] = i
+	}
+
+	for name, marshal := range map[string]func() ([]byte, error){
+		"serial":   m.MarshalJSON,
+		"parallel": m.MarshalJSONParallel,
+	} {
+		data, err := marshal()
+		require.NoError(t, err, name)
+		got := map[string]int{}
+		require.NoError(t, json.Unmarshal(data, &got), name)
+		require.Equal(t, want, got, name)
+	}
+
+	// json.Marshal picks up MarshalJSON, also for non-string keys.
+	ints := New[int, string](4)
+	ints.Set(1, "one")
+	data, err := json.Marshal(ints)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"1":"one"}`, string(data))
+
+	// Empty shards are skipped without stray commas.
+	data, err = ints.MarshalJSONParallel()
+	require.NoError(t, err)
+	require.JSONEq(t, `{"1":"one"}`, string(data))
+	data, err = New[string, int](4).MarshalJSONParallel()
+	require.NoError(t, err)
+	require.Equal(t, `{}`, string(data))
+}
+
+func TestMarshalJSONError(t *testing.T) {
+	m := New[string, func()](4)
+	m.Set("f", func() {})
+	_, err := m.MarshalJSON()
+	require.Error(t, err)
+	_, err = m.MarshalJSONParallel()
+	require.Error(t, err)
+}
+
+func BenchmarkMarshalJSON(b *testing.B) {
+	m := New[string, int](64)
+	for i := 0; i < 1000000; i++ {
+		m.Set(fmt.Sprintf("key_%d", i), i)
+	}
+	b.Run("serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := m.MarshalJSON(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := m.MarshalJSONParallel(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
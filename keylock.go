@@ -0,0 +1,50 @@
+package dmap
+
+import "sync"
+
+// keyLock is a reference-counted lock for a single key.
+type keyLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// WithKeyLock runs fn while holding an exclusive lock on key, so that
+// calls for the same key run one at a time while other keys proceed
+// concurrently. The lock is logical only: it is independent of the shard
+// locks, so fn is free to use the map (including key itself) and to do
+// slow work such as I/O without blocking other keys in the same shard.
+// Locks are reference-counted and dropped once no caller holds or waits
+// on them. WithKeyLock is not reentrant: fn must not lock key again.
+func (m DMap[K, V]) WithKeyLock(key K, fn func()) {
+	key = m.normalize(key)
+	st := m.state()
+	l := st.acquireKeyLock(key)
+	l.mu.Lock()
+	defer st.releaseKeyLock(key, l)
+	fn()
+}
+
+func (s *state[K, V]) acquireKeyLock(key K) *keyLock {
+	s.keyLocksMu.Lock()
+	defer s.keyLocksMu.Unlock()
+	if s.keyLocks == nil {
+		s.keyLocks = make(map[K]*keyLock)
+	}
+	l, ok := s.keyLocks[key]
+	if !ok {
+		l = &keyLock{}
+		s.keyLocks[key] = l
+	}
+	l.refs++
+	return l
+}
+
+func (s *state[K, V]) releaseKeyLock(key K, l *keyLock) {
+	l.mu.Unlock()
+	s.keyLocksMu.Lock()
+	defer s.keyLocksMu.Unlock()
+	l.refs--
+	if l.refs == 0 {
+		delete(s.keyLocks, key)
+	}
+}
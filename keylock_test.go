@@ -0,0 +1,75 @@
+package dmap
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithKeyLockSerializesSameKey(t *testing.T) {
+	m := New[string, int](4)
+	var inside, maxInside int32
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.WithKeyLock("a", func() {
+				n := atomic.AddInt32(&inside, 1)
+				if n > atomic.LoadInt32(&maxInside) {
+					atomic.StoreInt32(&maxInside, n)
+				}
+				time.Sleep(time.Millisecond)
+				v, _ := m.Get("a")
+				m.Set("a", v+1)
+				atomic.AddInt32(&inside, -1)
+			})
+		}()
+	}
+	wg.Wait()
+
+	require.EqualValues(t, 1, maxInside)
+	v, _ := m.Get("a")
+	require.Equal(t, 10, v)
+	require.Empty(t, m.state().keyLocks)
+}
+
+func TestWithKeyLockDifferentKeysConcurrent(t *testing.T) {
+	m := New[string, int](4)
+	aIn := make(chan struct{})
+	bIn := make(chan struct{})
+
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	var aSawB, bSawA bool
+	go func() {
+		defer wg.Done()
+		m.WithKeyLock("a", func() {
+			close(aIn)
+			select {
+			case <-bIn:
+				aSawB = true
+			case <-time.After(time.Second):
+			}
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		m.WithKeyLock("b", func() {
+			close(bIn)
+			select {
+			case <-aIn:
+				bSawA = true
+			case <-time.After(time.Second):
+			}
+		})
+	}()
+	wg.Wait()
+
+	require.True(t, aSawB && bSawA, "locks on different keys must not block each other")
+	require.Empty(t, m.state().keyLocks)
+}
@@ -0,0 +1,167 @@
+package dmap
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Close shuts the map down: it stops the goroutines started by
+// WithJanitor, WithWriteBehind and WithEventBuffer, runs a final
+// write-behind flush, clears the map, delivers any buffered events and
+// closes the channels of all subscriptions, returning the error of the
+// final flush.
+//
+// Close waits for in-flight writes to finish. Writes after Close are
+// dropped, so request handlers still running during shutdown do not
+// fail: writers that report whether they wrote report false, SetValidated,
+// RemoveE and GetOrComputeE return ErrClosed, and reads see an empty map.
+// A frozen map keeps its entries, and writes to it still panic with
+// ErrFrozen. Calling Close again is a no-op that returns the same error.
+func (m DMap[K, V]) Close() error {
+	st := m.state()
+	st.closeOnce.Do(func() {
+		if st.stop != nil {
+			close(st.stop)
+			st.background.Wait()
+		}
+		st.closeErr = m.closeShards()
+	})
+	return st.closeErr
+}
+
+// IsClosed reports whether Close has been called on the map.
+func (m DMap[K, V]) IsClosed() bool {
+	return m.state().isClosed()
+}
+
+func (s *state[K, V]) isClosed() bool {
+	return atomic.LoadInt32(&s.closed) == 1
+}
+
+// closeShards marks the map closed and clears it, then flushes the
+// entries that were dirty.
+func (m DMap[K, V]) closeShards() error {
+	indices := make([]int, len(m))
+	for i := range m {
+		indices[i] = i
+	}
+	st := m.state()
+	locks := m.lockShards(true, indices...)
+	var entries []Entry[K, V]
+	var events []Event[K, V]
+	for _, shard := range m {
+		entries = shard.takeDirty(entries)
+		events = append(events, shard.events...)
+		shard.events = nil
+		// Get and Has on a frozen map read without locking.
+		if !st.isFrozen() {
+			shard.clear()
+		}
+	}
+	atomic.StoreInt32(&st.closed, 1)
+	unlockShards(true, locks)
+	if len(events) > 0 {
+		st.publish(events...)
+	}
+	st.cancelSubscriptions()
+
+	if len(entries) == 0 {
+		return nil
+	}
+	return m.config().flush(entries)
+}
+
+// startBackground starts the goroutines the map is configured with.
+func (m DMap[K, V]) startBackground() {
+	cfg := m.config()
+	if cfg.janitor > 0 {
+		m.every(cfg.janitor, m.reapExpired)
+	}
+	if cfg.flush != nil && cfg.flushEvery > 0 {
+		m.every(cfg.flushEvery, func() { _ = m.flushDirty() })
+	}
+	if cfg.eventFlushEvery > 0 {
+		m.every(cfg.eventFlushEvery, m.flushEvents)
+	}
+}
+
+// every runs fn every interval on a background goroutine, until Close.
+func (m DMap[K, V]) every(interval time.Duration, fn func()) {
+	st := m.state()
+	if st.stop == nil {
+		st.stop = make(chan struct{})
+	}
+	st.background.Add(1)
+	go func() {
+		defer st.background.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-st.stop:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	}()
+}
+
+// reapExpired removes the expired entries of every shard.
+func (m DMap[K, V]) reapExpired() {
+	for _, shard := range m {
+		func() {
+			shard.mu.Lock()
+			defer shard.mu.Unlock()
+			if shard.state.writeErr() == nil {
+				shard.reapExpired()
+			}
+		}()
+	}
+}
+
+// flushDirty passes the entries set since the last flush to the
+// write-behind flush func. If that fails, the keys stay dirty.
+func (m DMap[K, V]) flushDirty() error {
+	var entries []Entry[K, V]
+	for _, shard := range m {
+		shard.mu.Lock()
+		entries = shard.takeDirty(entries)
+		shard.mu.Unlock()
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	err := m.config().flush(entries)
+	if err != nil {
+		for _, e := range entries {
+			shard := m.getShard(e.Key)
+			shard.mu.Lock()
+			shard.markDirty(e.Key)
+			shard.mu.Unlock()
+		}
+	}
+	return err
+}
+
+// markDirty records key as set since the last flush.
+// The caller must hold the shard's write lock.
+func (s *Shard[K, V]) markDirty(key K) {
+	if s.dirty == nil {
+		s.dirty = make(map[K]struct{})
+	}
+	s.dirty[key] = struct{}{}
+}
+
+// takeDirty appends the live entries of the dirty keys to entries and
+// resets the dirty set.
+// The caller must hold the shard's write lock.
+func (s *Shard[K, V]) takeDirty(entries []Entry[K, V]) []Entry[K, V] {
+	for k := range s.dirty {
+		if v, ok := s.lookup(k); ok {
+			entries = append(entries, Entry[K, V]{Key: k, Value: v})
+		}
+	}
+	s.dirty = nil
+	return entries
+}
@@ -0,0 +1,191 @@
+package dmap
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	var mu sync.Mutex
+	flushed := map[string]int{}
+	flushes := 0
+	flush := func(entries []Entry[string, int]) error {
+		mu.Lock()
+		defer mu.Unlock()
+		flushes++
+		for _, e := range entries {
+			flushed[e.Key] = e.Value
+		}
+		return nil
+	}
+	m := New[string, int](4,
+		WithJanitor[string, int](time.Millisecond),
+		WithWriteBehind(time.Hour, flush))
+	require.Greater(t, runtime.NumGoroutine(), before)
+
+	want := map[string]int{}
+	for i := 0; i < 100; i++ {
+		k := fmt.Sprintf("k%d", i)
+		m.Set(k, i)
+		want[k] = i
+	}
+	m.Set("k0", -1)
+	want["k0"] = -1
+	m.Remove("k1")
+	delete(want, "k1")
+
+	require.NoError(t, m.Close())
+	require.True(t, m.IsClosed())
+	require.Equal(t, 1, flushes)
+	require.Equal(t, want, flushed)
+	require.EqualValues(t, 0, m.Count())
+	require.False(t, m.Has("k2"))
+	// Not require.Eventually, which polls from a goroutine of its own.
+	for i := 0; runtime.NumGoroutine() > before; i++ {
+		require.Less(t, i, 1000, "background goroutines still running")
+		time.Sleep(time.Millisecond)
+	}
+
+	require.NoError(t, m.Close())
+	require.Equal(t, 1, flushes)
+	// Writes after Close are dropped.
+	m.Set("foo", 1)
+	m.Remove("foo")
+	m.AtomicSetAll(map[string]int{"foo": 1})
+	require.False(t, m.TrySet("foo", 1))
+	require.False(t, m.SetIfAbsent("foo", 1))
+	require.ErrorIs(t, m.SetValidated("foo", 1), ErrClosed)
+	require.ErrorIs(t, m.RemoveE("foo"), ErrClosed)
+	_, err := m.GetOrComputeE("foo", func() (int, error) { return 1, nil })
+	require.ErrorIs(t, err, ErrClosed)
+	require.Zero(t, m.Count())
+	require.False(t, m.Has("foo"))
+}
+
+func TestCloseConcurrentWrites(t *testing.T) {
+	var mu sync.Mutex
+	flushed := map[int]bool{}
+	m := New[int, int](8, WithWriteBehind(time.Millisecond, func(entries []Entry[int, int]) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, e := range entries {
+			flushed[e.Key] = true
+		}
+		return nil
+	}))
+
+	var written sync.Map
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; !m.IsClosed(); i++ {
+				k := w*1000000 + i
+				if m.TrySet(k, i) {
+					written.Store(k, true)
+				}
+			}
+		}(w)
+	}
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, m.Close())
+	wg.Wait()
+
+	// Every write that completed before Close was flushed.
+	written.Range(func(k, _ any) bool {
+		require.True(t, flushed[k.(int)], k)
+		return true
+	})
+}
+
+func TestWriteBehindRetry(t *testing.T) {
+	fail := errors.New("store down")
+	var mu sync.Mutex
+	var calls [][]Entry[string, int]
+	m := New[string, int](2, WithWriteBehind(time.Hour, func(entries []Entry[string, int]) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, entries)
+		if len(calls) == 1 {
+			return fail
+		}
+		return nil
+	}))
+	m.Set("a", 1)
+
+	require.ErrorIs(t, m.flushDirty(), fail)
+	require.NoError(t, m.flushDirty())
+	require.NoError(t, m.flushDirty())
+	require.Len(t, calls, 2)
+	require.Equal(t, []Entry[string, int]{{"a", 1}}, calls[1])
+	require.NoError(t, m.Close())
+	require.Len(t, calls, 2)
+}
+
+func TestCloseReturnsFlushError(t *testing.T) {
+	fail := errors.New("store down")
+	m := New[string, int](2, WithWriteBehind(time.Hour, func([]Entry[string, int]) error {
+		return fail
+	}))
+	m.Set("a", 1)
+	require.ErrorIs(t, m.Close(), fail)
+	require.ErrorIs(t, m.Close(), fail)
+}
+
+func TestJanitor(t *testing.T) {
+	m := New[string, int](4, WithJanitor[string, int](time.Millisecond))
+	defer m.Close()
+	m.SetWithTTL("short", 1, time.Millisecond)
+	m.Set("forever", 2)
+	require.Eventually(t, func() bool {
+		return m.Count() == 1
+	}, time.Second, time.Millisecond)
+	require.True(t, m.Has("forever"))
+}
+
+func TestCloseFrozen(t *testing.T) {
+	m := New[string, int](2)
+	m.Set("a", 1)
+	m.Freeze()
+	require.NoError(t, m.Close())
+	v, ok := m.Get("a")
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+	require.PanicsWithValue(t, ErrFrozen, func() { m.Set("b", 2) })
+}
+
+func TestDerivedMapsHaveNoBackground(t *testing.T) {
+	m := New[string, int](2, WithJanitor[string, int](time.Millisecond))
+	defer m.Close()
+	c := m.Clone()
+	require.Nil(t, c.state().stop)
+}
+
+func TestJanitorWithClock(t *testing.T) {
+	clock := newFakeClock()
+	m := New[string, int](4,
+		WithClock[string, int](clock),
+		WithJanitor[string, int](time.Millisecond))
+	defer m.Close()
+	m.SetWithTTL("short", 1, time.Minute)
+	m.Set("forever", 2)
+
+	// The janitor runs, but nothing has expired by the clock yet.
+	time.Sleep(5 * time.Millisecond)
+	require.EqualValues(t, 2, m.Count())
+
+	clock.Advance(time.Minute)
+	require.Eventually(t, func() bool {
+		return m.Count() == 1
+	}, time.Second, time.Millisecond)
+	require.True(t, m.Has("forever"))
+}
@@ -0,0 +1,197 @@
+package dmap
+
+import (
+	"errors"
+	"time"
+)
+
+// Loader fetches the value for a key from a backing store.
+// It should return ErrKeyNotFound if the key does not exist there.
+type Loader[K comparable, V any] func(key K) (V, error)
+
+// GetOrLoad returns the value for key, calling loader on a miss and
+// storing the loaded value in the map.
+// loader runs without any shard lock held, so concurrent misses for the
+// same key may each call it; the last one to finish wins.
+// Errors from loader are returned as is and nothing is stored.
+func (m DMap[K, V]) GetOrLoad(key K, loader Loader[K, V]) (V, error) {
+	if v, ok := m.Get(key); ok {
+		return v, nil
+	}
+	v, err := loader(key)
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+	m.Set(key, v)
+	return v, nil
+}
+
+// GetOrLoadWithNegativeCache is like GetOrLoad, but when loader reports
+// ErrKeyNotFound it remembers the miss for negTTL. Until then, further
+// calls for key return ErrKeyNotFound without calling loader.
+// The negative entry is invisible to Get, Has, Keys and Count, and is
+// discarded as soon as key is Set.
+func (m DMap[K, V]) GetOrLoadWithNegativeCache(key K, loader Loader[K, V], negTTL time.Duration) (V, error) {
+	key = m.normalize(key)
+	var zero V
+	shard := m.getShard(key)
+	if shard.knownMissing(key) {
+		return zero, ErrKeyNotFound
+	}
+	if v, ok := m.Get(key); ok {
+		return v, nil
+	}
+	v, err := loader(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		shard.markMissing(key, shard.now().Add(negTTL))
+		return zero, err
+	}
+	if err != nil {
+		return zero, err
+	}
+	m.Set(key, v)
+	return v, nil
+}
+
+func (s *Shard[K, V]) knownMissing(key K) bool {
+	s.mu.RLock()
+	expireAt, ok := s.missing[key]
+	s.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	if s.now().Before(expireAt) {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Re-check: the tombstone may have been refreshed meanwhile.
+	if exp, ok := s.missing[key]; ok && !s.now().Before(exp) {
+		delete(s.missing, key)
+	}
+	return false
+}
+
+func (s *Shard[K, V]) markMissing(key K, expireAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// A concurrent Set may have stored the key while the loader ran.
+	if s.contains(key) {
+		return
+	}
+	if s.missing == nil {
+		s.missing = make(map[K]time.Time)
+	}
+	s.missing[key] = expireAt
+}
+
+// loadErr is a loader error cached by GetWithLoader until expireAt.
+type loadErr struct {
+	err      error
+	expireAt time.Time
+}
+
+// GetWithLoader is like GetOrLoad, but caches loader errors for errTTL,
+// e.g. to fail fast while a flaky upstream is down. Until the cached
+// error expires, further calls for key return it without calling loader;
+// the first call after that retries. Like the negative entries of
+// GetOrLoadWithNegativeCache, cached errors are invisible to reads and
+// discarded as soon as key is Set.
+func (m DMap[K, V]) GetWithLoader(key K, loader Loader[K, V], errTTL time.Duration) (V, error) {
+	key = m.normalize(key)
+	var zero V
+	shard := m.getShard(key)
+	if err := shard.cachedLoadErr(key); err != nil {
+		return zero, err
+	}
+	if v, ok := m.Get(key); ok {
+		return v, nil
+	}
+	v, err := loader(key)
+	if err != nil {
+		shard.cacheLoadErr(key, err, shard.now().Add(errTTL))
+		return zero, err
+	}
+	m.Set(key, v)
+	return v, nil
+}
+
+func (s *Shard[K, V]) cachedLoadErr(key K) error {
+	s.mu.RLock()
+	cached, ok := s.loadErrs[key]
+	s.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	if s.now().Before(cached.expireAt) {
+		return cached.err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Re-check: the error may have been refreshed meanwhile.
+	if c, ok := s.loadErrs[key]; ok && !s.now().Before(c.expireAt) {
+		delete(s.loadErrs, key)
+	}
+	return nil
+}
+
+func (s *Shard[K, V]) cacheLoadErr(key K, err error, expireAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// A concurrent Set may have stored the key while the loader ran.
+	if s.contains(key) {
+		return
+	}
+	if s.loadErrs == nil {
+		s.loadErrs = make(map[K]loadErr)
+	}
+	s.loadErrs[key] = loadErr{err, expireAt}
+}
+
+// GetOrCompute returns the value for key, or, if key is absent, stores
+// and returns fn(). fn runs under the shard's write lock, so it is
+// called at most once per miss even under concurrent calls for the same
+// key, and it must not access the map.
+func (m DMap[K, V]) GetOrCompute(key K, fn func() V) V {
+	v, _ := m.GetOrComputeE(key, func() (V, error) { return fn(), nil })
+	return v
+}
+
+// GetOrComputeE is like GetOrCompute for builders that can fail: if fn
+// returns an error, nothing is stored and the error is returned.
+func (m DMap[K, V]) GetOrComputeE(key K, fn func() (V, error)) (V, error) {
+	key = m.normalize(key)
+	v, added, err := m.getShard(key).getOrCompute(key, fn)
+	if added {
+		m.evictOverflow(key)
+	}
+	return v, err
+}
+
+func (s *Shard[K, V]) getOrCompute(key K, fn func() (V, error)) (V, bool, error) {
+	if !s.lockWrite() {
+		var zero V
+		return zero, false, ErrClosed
+	}
+	defer s.mu.Unlock()
+	if v, ok := s.lookup(key); ok {
+		return s.readCopy(v), false, nil
+	}
+	var v V
+	var err error
+	if !s.cfg.guard(func() { v, err = fn() }) {
+		err = ErrCallbackPanicked
+	}
+	if err == nil {
+		err = s.validate(key, v)
+	}
+	if err != nil {
+		var zero V
+		return zero, false, err
+	}
+	_, grew := s.set(key, v)
+	return s.readCopy(v), grew, nil
+}
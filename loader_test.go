@@ -0,0 +1,155 @@
+package dmap
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetOrLoad(t *testing.T) {
+	m := New[string, string](10)
+	calls := 0
+	loader := func(key string) (string, error) {
+		calls++
+		return "loaded " + key, nil
+	}
+
+	v, err := m.GetOrLoad("foo", loader)
+	require.NoError(t, err)
+	require.Equal(t, "loaded foo", v)
+
+	v, err = m.GetOrLoad("foo", loader)
+	require.NoError(t, err)
+	require.Equal(t, "loaded foo", v)
+	require.Equal(t, 1, calls)
+
+	boom := errors.New("boom")
+	_, err = m.GetOrLoad("bar", func(string) (string, error) { return "", boom })
+	require.ErrorIs(t, err, boom)
+	require.False(t, m.Has("bar"))
+}
+
+func TestGetOrLoadWithNegativeCache(t *testing.T) {
+	m := New[string, string](10)
+	calls := 0
+	loader := func(string) (string, error) {
+		calls++
+		return "", ErrKeyNotFound
+	}
+
+	for i := 0; i < 5; i++ {
+		_, err := m.GetOrLoadWithNegativeCache("foo", loader, 50*time.Millisecond)
+		require.ErrorIs(t, err, ErrKeyNotFound)
+	}
+	require.Equal(t, 1, calls)
+	require.False(t, m.Has("foo"))
+	require.EqualValues(t, 0, m.Count())
+
+	time.Sleep(60 * time.Millisecond)
+	_, err := m.GetOrLoadWithNegativeCache("foo", loader, 50*time.Millisecond)
+	require.ErrorIs(t, err, ErrKeyNotFound)
+	require.Equal(t, 2, calls)
+
+	// Setting the key clears the tombstone.
+	m.Set("foo", "bar")
+	v, err := m.GetOrLoadWithNegativeCache("foo", loader, 50*time.Millisecond)
+	require.NoError(t, err)
+	require.Equal(t, "bar", v)
+	require.Equal(t, 2, calls)
+}
+
+func TestGetWithLoader(t *testing.T) {
+	clock := newFakeClock()
+	m := New[string, string](10, WithClock[string, string](clock))
+	errUpstream := errors.New("upstream down")
+	calls := 0
+	failing := true
+	loader := func(k string) (string, error) {
+		calls++
+		if failing {
+			return "", errUpstream
+		}
+		return "val_" + k, nil
+	}
+
+	_, err := m.GetWithLoader("a", loader, time.Minute)
+	require.ErrorIs(t, err, errUpstream)
+	clock.Advance(time.Minute - time.Nanosecond)
+	_, err = m.GetWithLoader("a", loader, time.Minute)
+	require.ErrorIs(t, err, errUpstream)
+	require.Equal(t, 1, calls, "cached error is returned without calling loader")
+	require.False(t, m.Has("a"))
+	require.Zero(t, m.Count())
+
+	// The upstream recovers; the error window ends and loader is retried.
+	failing = false
+	clock.Advance(time.Nanosecond)
+	v, err := m.GetWithLoader("a", loader, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, "val_a", v)
+	require.Equal(t, 2, calls)
+	v, _ = m.GetWithLoader("a", loader, time.Minute)
+	require.Equal(t, "val_a", v)
+	require.Equal(t, 2, calls)
+
+	// A Set discards the cached error.
+	failing = true
+	_, err = m.GetWithLoader("b", loader, time.Hour)
+	require.Error(t, err)
+	m.Set("b", "set")
+	v, err = m.GetWithLoader("b", loader, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, "set", v)
+}
+
+func TestGetOrCompute(t *testing.T) {
+	m := New[string, int](4)
+	require.Equal(t, 1, m.GetOrCompute("a", func() int { return 1 }))
+	require.Equal(t, 1, m.GetOrCompute("a", func() int { return 2 }))
+	require.EqualValues(t, 1, m.Count())
+}
+
+func TestGetOrComputeE(t *testing.T) {
+	m := New[string, int](4)
+
+	v, err := m.GetOrComputeE("a", func() (int, error) { return 1, nil })
+	require.NoError(t, err)
+	require.Equal(t, 1, v)
+	v, err = m.GetOrComputeE("a", func() (int, error) {
+		t.Fatal("fn must not run on a hit")
+		return 0, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, v)
+
+	boom := errors.New("boom")
+	_, err = m.GetOrComputeE("b", func() (int, error) { return 2, boom })
+	require.ErrorIs(t, err, boom)
+	require.False(t, m.Has("b"))
+	require.EqualValues(t, 1, m.Count())
+}
+
+func TestGetOrComputeEConcurrent(t *testing.T) {
+	m := New[string, int](4)
+	var calls int32
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := m.GetOrComputeE("a", func() (int, error) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(time.Millisecond)
+				return 42, nil
+			})
+			require.NoError(t, err)
+			require.Equal(t, 42, v)
+		}()
+	}
+	wg.Wait()
+	require.EqualValues(t, 1, calls)
+}
@@ -0,0 +1,179 @@
+package dmap
+
+import (
+	"container/list"
+	"sort"
+	"sync/atomic"
+)
+
+// lruEntry is an element of a shard's lru list.
+type lruEntry[K comparable] struct {
+	key  K
+	size int64
+	// used is the state's tick at the last use of key.
+	used uint64
+}
+
+// MemoryUsage returns the estimated size of all values in the map, as
+// measured WithMemoryBudget, or 0 without it.
+func (m DMap[K, V]) MemoryUsage() int64 {
+	return atomic.LoadInt64(&m.state().bytes)
+}
+
+// track records a store of val for key as its most recent use and
+// returns the change in estimated bytes.
+// The caller must hold the shard's write lock.
+func (s *Shard[K, V]) track(key K, val V) int64 {
+	size := s.cfg.sizeOf(val)
+	used := atomic.AddUint64(&s.state.tick, 1)
+	if s.lru == nil {
+		s.lru = list.New()
+		s.lruElems = make(map[K]*list.Element)
+	}
+	delta := size
+	if el, ok := s.lruElems[key]; ok {
+		e := el.Value.(*lruEntry[K])
+		delta -= e.size
+		e.size, e.used = size, used
+		s.lru.MoveToFront(el)
+	} else {
+		s.lruElems[key] = s.lru.PushFront(&lruEntry[K]{key: key, size: size, used: used})
+	}
+	s.bytes += delta
+	atomic.AddInt64(&s.state.bytes, delta)
+	return delta
+}
+
+// untrack forgets key.
+// The caller must hold the shard's write lock.
+func (s *Shard[K, V]) untrack(key K) {
+	el, ok := s.lruElems[key]
+	if !ok {
+		return
+	}
+	s.lru.Remove(el)
+	delete(s.lruElems, key)
+	size := el.Value.(*lruEntry[K]).size
+	s.bytes -= size
+	atomic.AddInt64(&s.state.bytes, -size)
+}
+
+// untrackAll forgets every key of the shard.
+// The caller must hold the shard's write lock.
+func (s *Shard[K, V]) untrackAll() {
+	var bytes int64
+	for _, el := range s.lruElems {
+		bytes += el.Value.(*lruEntry[K]).size
+	}
+	atomic.AddInt64(&s.state.bytes, -bytes)
+	s.lru, s.lruElems = nil, nil
+	s.bytes = 0
+}
+
+// touch marks key as just used.
+// The caller must hold the shard's lock, for reading at least.
+func (s *Shard[K, V]) touch(key K) {
+	s.lruMu.Lock()
+	defer s.lruMu.Unlock()
+	if el, ok := s.lruElems[key]; ok {
+		el.Value.(*lruEntry[K]).used = atomic.AddUint64(&s.state.tick, 1)
+		s.lru.MoveToFront(el)
+	}
+}
+
+// evictOverBudget removes least recently used entries until the map is
+// back within its WithMemoryBudget, never choosing one of keep.
+// Each victim is the least recently used entry of the map as of the
+// eviction, found by comparing the oldest entry of every shard.
+func (m DMap[K, V]) evictOverBudget(keep []K) {
+	budget := m.config().memBudget
+	if budget <= 0 {
+		return
+	}
+	st := m.state()
+	for atomic.LoadInt64(&st.bytes) > budget {
+		evicted := false
+		// Oldest shards first, moving on when all their entries are kept.
+		for _, shard := range m.shardsByOldestUse() {
+			if shard.evictLRU(keep) {
+				evicted = true
+				break
+			}
+		}
+		if !evicted {
+			return
+		}
+	}
+}
+
+// shardsByOldestUse returns the shards holding tracked entries, ordered
+// by the last use of their least recently used entry.
+func (m DMap[K, V]) shardsByOldestUse() []*Shard[K, V] {
+	type oldest struct {
+		shard *Shard[K, V]
+		used  uint64
+	}
+	var found []oldest
+	for _, shard := range m {
+		shard.mu.RLock()
+		shard.lruMu.Lock()
+		if shard.lru != nil && shard.lru.Len() > 0 {
+			used := shard.lru.Back().Value.(*lruEntry[K]).used
+			found = append(found, oldest{shard, used})
+		}
+		shard.lruMu.Unlock()
+		shard.mu.RUnlock()
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].used < found[j].used })
+	shards := make([]*Shard[K, V], len(found))
+	for i, o := range found {
+		shards[i] = o.shard
+	}
+	return shards
+}
+
+// evictLRU removes the least recently used entry of the shard not in
+// keep, reporting whether there was one.
+func (s *Shard[K, V]) evictLRU(keep []K) bool {
+	if !s.lockWrite() {
+		return false
+	}
+	defer s.mu.Unlock()
+	if s.lru == nil {
+		return false
+	}
+next:
+	for el := s.lru.Back(); el != nil; el = el.Prev() {
+		key := el.Value.(*lruEntry[K]).key
+		for _, k := range keep {
+			if k == key {
+				continue next
+			}
+		}
+		return s.delete(key)
+	}
+	return false
+}
+
+// evictToLimits removes the least recently used entries of the shard,
+// except keep, until it is within its WithShardLimits.
+// The caller must hold the shard's write lock.
+func (s *Shard[K, V]) evictToLimits(keep K) {
+	if s.lru == nil {
+		return
+	}
+	for el := s.lru.Back(); el != nil && s.overLimits(); {
+		prev := el.Prev()
+		if key := el.Value.(*lruEntry[K]).key; key != keep {
+			s.delete(key)
+		}
+		el = prev
+	}
+}
+
+// overLimits reports whether the shard exceeds its WithShardLimits.
+// The caller must hold the shard's lock.
+func (s *Shard[K, V]) overLimits() bool {
+	max, maxBytes := s.cfg.shardMaxEntries, s.cfg.shardMaxBytes
+	return max > 0 && s.count > max || maxBytes > 0 && s.bytes > maxBytes
+}
@@ -0,0 +1,139 @@
+package dmap
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func blobSize(b []byte) int64 { return int64(len(b)) }
+
+func TestMemoryBudget(t *testing.T) {
+	m := New[string, []byte](4, WithMemoryBudget[string, []byte](1000, blobSize))
+	for i := 0; i < 10; i++ {
+		m.Set(fmt.Sprintf("k%d", i), make([]byte, 100))
+	}
+	require.EqualValues(t, 1000, m.MemoryUsage())
+	require.EqualValues(t, 10, m.Count())
+
+	// k0 is now the most recently used; k1 the least.
+	_, ok := m.Get("k0")
+	require.True(t, ok)
+
+	m.Set("big", make([]byte, 250))
+	require.LessOrEqual(t, m.MemoryUsage(), int64(1000))
+	require.EqualValues(t, 950, m.MemoryUsage())
+	for _, k := range []string{"k1", "k2", "k3"} {
+		require.False(t, m.Has(k), k)
+	}
+	for _, k := range []string{"k0", "k4", "k9", "big"} {
+		require.True(t, m.Has(k), k)
+	}
+
+	// Growing a value evicts too; shrinking frees budget.
+	m.Set("k0", make([]byte, 200))
+	require.LessOrEqual(t, m.MemoryUsage(), int64(1000))
+	require.False(t, m.Has("k4"))
+	m.Set("big", nil)
+	require.EqualValues(t, 700, m.MemoryUsage())
+
+	m.Remove("k0")
+	require.EqualValues(t, 500, m.MemoryUsage())
+	m.Clear()
+	require.Zero(t, m.MemoryUsage())
+}
+
+func TestMemoryBudgetOversizedValue(t *testing.T) {
+	m := New[string, []byte](4, WithMemoryBudget[string, []byte](100, blobSize))
+	m.Set("a", make([]byte, 50))
+	m.Set("huge", make([]byte, 500))
+	require.False(t, m.Has("a"))
+	require.True(t, m.Has("huge"))
+	require.EqualValues(t, 500, m.MemoryUsage())
+}
+
+func TestMemoryBudgetManyWrites(t *testing.T) {
+	m := New[int, []byte](8, WithMemoryBudget[int, []byte](10000, blobSize))
+	for i := 0; i < 5000; i++ {
+		m.Set(i, make([]byte, i%37))
+		require.LessOrEqual(t, m.MemoryUsage(), int64(10000))
+	}
+	var sum int64
+	m.ForEach(func(_ int, v []byte) bool {
+		sum += blobSize(v)
+		return true
+	})
+	require.Equal(t, sum, m.MemoryUsage())
+
+	m.ReplaceAll(map[int][]byte{1: make([]byte, 10), 2: make([]byte, 20)})
+	require.EqualValues(t, 30, m.MemoryUsage())
+}
+
+func TestPeek(t *testing.T) {
+	m := New[string, []byte](4,
+		WithMemoryBudget[string, []byte](300, blobSize),
+		WithHitStats[string, []byte]())
+	m.Set("oldest", []byte("0123456789"))
+	m.Set("b", make([]byte, 100))
+	m.Set("c", make([]byte, 100))
+
+	for i := 0; i < 10; i++ {
+		v, ok := m.Peek("oldest")
+		require.True(t, ok)
+		require.Equal(t, "0123456789", string(v))
+	}
+	_, ok := m.Peek("missing")
+	require.False(t, ok)
+	require.Zero(t, m.Stats().Hits+m.Stats().Misses)
+
+	m.Set("d", make([]byte, 100))
+	require.False(t, m.Has("oldest"), "peeking must not protect from eviction")
+	require.True(t, m.Has("b"))
+
+	// A Get, by contrast, makes "b" recently used, so "c" goes next.
+	m.Get("b")
+	m.Set("e", make([]byte, 100))
+	require.True(t, m.Has("b"))
+	require.False(t, m.Has("c"))
+}
+
+func TestShardLimitsEntries(t *testing.T) {
+	m := New[string, []byte](4, WithShardLimits[string, []byte](10, 1<<20, blobSize))
+	for i := 0; i < 1000; i++ {
+		m.Set(fmt.Sprintf("k%d", i), make([]byte, 10))
+		for _, shard := range m {
+			require.LessOrEqual(t, len(shard.items), 10)
+			require.LessOrEqual(t, shard.bytes, int64(1<<20))
+		}
+	}
+	require.EqualValues(t, 40, m.Count())
+	require.EqualValues(t, 400, m.MemoryUsage())
+	require.True(t, m.Has("k999"), "the key written is kept")
+}
+
+func TestShardLimitsBytes(t *testing.T) {
+	m := New[string, []byte](4, WithShardLimits[string, []byte](1000, 250, blobSize))
+	for i := 0; i < 1000; i++ {
+		m.Set(fmt.Sprintf("k%d", i), make([]byte, 100))
+		for _, shard := range m {
+			require.LessOrEqual(t, len(shard.items), 1000)
+			require.LessOrEqual(t, shard.bytes, int64(250))
+		}
+	}
+	require.EqualValues(t, 8, m.Count())
+	require.EqualValues(t, 800, m.MemoryUsage())
+}
+
+func TestShardLimitsLRU(t *testing.T) {
+	m := New[int, int](1, WithShardLimits[int, int](3, 0, nil))
+	m.Set(1, 1)
+	m.Set(2, 2)
+	m.Set(3, 3)
+	m.Get(1)
+	m.Set(4, 4)
+	require.ElementsMatch(t, []int{1, 3, 4}, m.Keys())
+	m.Set(3, 30)
+	m.Set(5, 5)
+	require.ElementsMatch(t, []int{3, 4, 5}, m.Keys())
+}
@@ -0,0 +1,219 @@
+package dmap
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// lockShards locks the shards at indices (for writing if write is set)
+// and returns the locks taken, to be released with unlockShards.
+// Each distinct lock is taken once, in ascending stripe order; as long
+// as every multi-shard operation goes through here, two of them can
+// never wait on each other's locks in a cycle.
+func (m DMap[K, V]) lockShards(write bool, indices ...int) []*sync.RWMutex {
+	seen := make(map[int]*sync.RWMutex, len(indices))
+	for _, i := range indices {
+		seen[m[i].stripe] = m[i].mu
+	}
+	stripes := make([]int, 0, len(seen))
+	for stripe := range seen {
+		stripes = append(stripes, stripe)
+	}
+	sort.Ints(stripes)
+
+	locks := make([]*sync.RWMutex, len(stripes))
+	for i, stripe := range stripes {
+		locks[i] = seen[stripe]
+		if write {
+			locks[i].Lock()
+		} else {
+			locks[i].RLock()
+		}
+	}
+	return locks
+}
+
+// unlockShards releases locks taken by lockShards, in reverse order.
+func unlockShards(write bool, locks []*sync.RWMutex) {
+	for i := len(locks) - 1; i >= 0; i-- {
+		if write {
+			locks[i].Unlock()
+		} else {
+			locks[i].RUnlock()
+		}
+	}
+}
+
+// AtomicSetAll sets all the given key, value pairs as one atomic step:
+// every shard involved is write-locked before any entry is written, so
+// readers using AtomicGetAll see either none or all of items.
+// (Single-key reads such as Get are atomic per key only.)
+//
+// Holding several shard locks at once blocks all access to those shards
+// for the duration of the write, so prefer SetMany when per-shard
+// atomicity is enough. Locks are always acquired in ascending order to
+// avoid deadlocks with other multi-shard operations.
+func (m DMap[K, V]) AtomicSetAll(items map[K]V) {
+	items = normalizeMap(m.config().normalizer, items)
+	groups := m.groupByShard(items)
+	indices := make([]int, 0, len(groups))
+	for i, keys := range groups {
+		if m[i].cfg.validator != nil {
+			keys = m[i].validKeys(keys, items)
+			groups[i] = keys
+		}
+		if len(keys) > 0 {
+			indices = append(indices, i)
+		}
+	}
+
+	defer m.evictOverflow()
+	locks := m.lockShards(true, indices...)
+	defer unlockShards(true, locks)
+	if !m.state().canWrite() {
+		return
+	}
+	for _, i := range indices {
+		for _, key := range groups[i] {
+			m[i].set(key, items[key])
+		}
+	}
+}
+
+// AtomicGetAll returns the values of the present keys among keys, read as
+// one atomic step by read-locking every shard involved (see AtomicSetAll).
+func (m DMap[K, V]) AtomicGetAll(keys []K) map[K]V {
+	keys = m.normalizeKeys(keys)
+	indices := make([]int, len(keys))
+	for i, key := range keys {
+		indices[i] = m.getShardIndex(key)
+	}
+
+	locks := m.lockShards(false, indices...)
+	defer unlockShards(false, locks)
+	found := make(map[K]V, len(keys))
+	for i, key := range keys {
+		if v, ok := m[indices[i]].lookup(key); ok {
+			found[key] = v
+		}
+	}
+	return found
+}
+
+// ReplaceAll atomically replaces the entire contents of the map with
+// items, e.g. to hot-reload a config cache. The new shard maps are built
+// before any lock is taken; then every shard is write-locked at once and
+// swapped, so AtomicGetAll (and any other multi-shard read) sees either
+// the complete old or the complete new dataset. Single-key reads such as
+// Get are atomic per key only. TTLs, routes and negative-cache entries
+// of the old contents are dropped.
+func (m DMap[K, V]) ReplaceAll(items map[K]V) {
+	items = normalizeMap(m.config().normalizer, items)
+	fresh := make([]map[K]V, len(m))
+	for i, keys := range m.groupByShard(items) {
+		if m[i].cfg.validator != nil {
+			keys = m[i].validKeys(keys, items)
+		}
+		if len(keys) == 0 && m.config().lazyShards {
+			continue
+		}
+		fresh[i] = make(map[K]V, len(keys))
+		for _, key := range keys {
+			fresh[i][key] = items[key]
+		}
+	}
+
+	indices := make([]int, len(m))
+	for i := range m {
+		indices[i] = i
+	}
+	defer m.evictOverflow()
+	locks := m.lockShards(true, indices...)
+	defer unlockShards(true, locks)
+	if !m.state().canWrite() {
+		return
+	}
+	st := m.state()
+	st.routeMu.Lock()
+	st.routes, st.displaced = nil, nil
+	st.routeMu.Unlock()
+	for i, shard := range m {
+		if shard.cfg.maxTotal > 0 {
+			atomic.AddInt64(&shard.state.total, int64(len(fresh[i]))-shard.count)
+		}
+		if shard.cfg.sizeOf != nil {
+			shard.untrackAll()
+			for key, val := range fresh[i] {
+				shard.track(key, val)
+			}
+		}
+		if shard.bloom != nil {
+			for key := range fresh[i] {
+				shard.bloom.add(hashKeySeed(key, bloomSeed))
+			}
+		}
+		shard.items = fresh[i]
+		shard.scanKeys = nil
+		shard.count = int64(len(fresh[i]))
+		shard.expires = nil
+		shard.missing = nil
+		shard.loadErrs = nil
+		shard.versions = nil
+		if shard.cfg.versioning {
+			for key := range shard.items {
+				shard.bumpVersion(key)
+			}
+		}
+		for key, val := range shard.items {
+			if len(shard.waiters) == 0 {
+				break
+			}
+			if _, ok := shard.waiters[key]; ok {
+				shard.wake(key, val)
+			}
+		}
+	}
+	if m.config().replicas > 1 {
+		for _, shard := range m {
+			shard.replicaMu.Lock()
+			shard.replicas = nil
+			shard.replicaMu.Unlock()
+		}
+		for _, shard := range m {
+			for key, val := range shard.items {
+				shard.replicate(key, val, false)
+			}
+		}
+	}
+}
+
+// Rename atomically moves the entry for from, expiry included, to the key
+// to, replacing any entry to had. It reports whether the entry was moved:
+// false if from was absent, or if to is new and the map, full, rejects
+// it WithRejectOnFull. Either way the map is left unchanged.
+func (m DMap[K, V]) Rename(from, to K) bool {
+	from, to = m.normalize(from), m.normalize(to)
+	src, dst := m.getShardIndex(from), m.getShardIndex(to)
+	defer m.dropDisplaced()
+	locks := m.lockShards(true, src, dst)
+	defer unlockShards(true, locks)
+	if !m.state().canWrite() {
+		return false
+	}
+	v, ok := m[src].lookup(from)
+	if !ok || from == to {
+		return ok
+	}
+	expireAt, expires := m[src].expires[from]
+	m[src].delete(from)
+	if stored, _ := m[dst].set(to, v); !stored {
+		// Rejected WithRejectOnFull: put from back.
+		to, dst = from, src
+		m[dst].set(to, v)
+	}
+	if expires {
+		m[dst].setExpireAt(to, expireAt)
+	}
+	return to != from
+}
@@ -0,0 +1,184 @@
+package dmap
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAtomicSetAll(t *testing.T) {
+	m := New[string, int](8, WithLockStripes[string, int](3))
+	keys := make([]string, 20)
+	shards := map[int]bool{}
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key_%d", i)
+		shards[m.getShardIndex(keys[i])] = true
+	}
+	require.Greater(t, len(shards), 1)
+
+	batch := func(gen int) map[string]int {
+		items := make(map[string]int, len(keys))
+		for _, k := range keys {
+			items[k] = gen
+		}
+		return items
+	}
+
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for gen := 1; gen <= 500; gen++ {
+			m.AtomicSetAll(batch(gen))
+		}
+		close(done)
+	}()
+
+	for {
+		got := m.AtomicGetAll(keys)
+		if len(got) > 0 {
+			require.Len(t, got, len(keys), "saw a partial write")
+			gen := got[keys[0]]
+			for _, k := range keys {
+				require.Equal(t, gen, got[k], "saw a mix of two writes")
+			}
+		}
+
+		select {
+		case <-done:
+			wg.Wait()
+			require.EqualValues(t, len(keys), m.Count())
+			require.Equal(t, batch(500), m.AtomicGetAll(keys))
+			return
+		default:
+		}
+	}
+}
+
+func TestLockShardsDedupesStripes(t *testing.T) {
+	m := New[string, int](8, WithLockStripes[string, int](3))
+
+	locks := m.lockShards(true, 7, 1, 4, 0, 3)
+	require.Len(t, locks, 2)
+	require.Same(t, m[0].mu, locks[0])
+	require.Same(t, m[1].mu, locks[1])
+	unlockShards(true, locks)
+
+	// All locks must be free again.
+	for _, shard := range m {
+		shard.mu.Lock()
+		shard.mu.Unlock()
+	}
+}
+
+func TestReplaceAll(t *testing.T) {
+	m := New[string, string](8)
+	oldKeys := make([]string, 20)
+	newKeys := make([]string, 20)
+	oldSet := map[string]string{}
+	newSet := map[string]string{}
+	for i := range oldKeys {
+		oldKeys[i] = fmt.Sprintf("old_%d", i)
+		newKeys[i] = fmt.Sprintf("new_%d", i)
+		oldSet[oldKeys[i]] = "old"
+		newSet[newKeys[i]] = "new"
+	}
+	m.SetMany(oldSet)
+	all := append(append([]string{}, oldKeys...), newKeys...)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			if i%2 == 0 {
+				m.ReplaceAll(newSet)
+			} else {
+				m.ReplaceAll(oldSet)
+			}
+		}
+	}()
+
+	for {
+		got := m.AtomicGetAll(all)
+		if !reflect.DeepEqual(oldSet, got) && !reflect.DeepEqual(newSet, got) {
+			t.Fatalf("saw a blend of datasets: %v", got)
+		}
+		select {
+		case <-done:
+			require.Equal(t, oldSet, m.Items())
+			require.EqualValues(t, len(oldSet), m.Count())
+			return
+		default:
+		}
+	}
+}
+
+func TestRename(t *testing.T) {
+	m := New[string, int](8)
+	m.Set("a", 1)
+	m.SetWithTTL("t", 2, time.Hour)
+	m.Set("taken", 3)
+
+	require.True(t, m.Rename("a", "b"))
+	require.False(t, m.Has("a"))
+	v, _ := m.Get("b")
+	require.Equal(t, 1, v)
+
+	require.True(t, m.Rename("t", "taken"))
+	v, _ = m.Get("taken")
+	require.Equal(t, 2, v)
+	require.Contains(t, m.getShard("taken").expires, "taken")
+	require.EqualValues(t, 2, m.Count())
+
+	require.False(t, m.Rename("missing", "b"))
+	require.True(t, m.Rename("b", "b"))
+	require.Equal(t, map[string]int{"b": 1, "taken": 2}, m.Items())
+}
+
+// TestMultiShardStress runs multi-shard operations against each other;
+// with a consistent lock order none of them can deadlock.
+func TestMultiShardStress(t *testing.T) {
+	m := New[int, int](16, WithLockStripes[int, int](5))
+	const nkeys = 64
+	for i := 0; i < nkeys; i++ {
+		m.Set(i, i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		for w := 0; w < 8; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < 2000; i++ {
+					a, b := (w*31+i*7)%nkeys, (w*17+i*13)%nkeys
+					switch i % 4 {
+					case 0:
+						m.Rename(a, b)
+					case 1:
+						m.AtomicSetAll(map[int]int{a: i, b: i, (a + 1) % nkeys: i})
+					case 2:
+						m.AtomicGetAll([]int{b, a})
+					case 3:
+						m.Rename(b, a)
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(20 * time.Second):
+		t.Fatal("multi-shard operations deadlocked")
+	}
+	require.LessOrEqual(t, m.Count(), int64(nkeys))
+}
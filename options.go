@@ -0,0 +1,535 @@
+package dmap
+
+import "time"
+
+// Option configures a DMap on construction.
+type Option[K comparable, V any] func(*config[K, V])
+
+// config holds the settings collected from Options.
+// A config is shared by all shards of a map and never modified after
+// construction.
+type config[K comparable, V any] struct {
+	lockStripes     int
+	stripeTable     []int
+	lazyShards      bool
+	hasher          func(K) uint64
+	keyEncoder      func(K) []byte
+	normalizer      func(K) K
+	hashSeed        uint64
+	weights         []int
+	weightTable     []int
+	hitStats        bool
+	opStats         bool
+	fanOutLimit     int
+	replicas        int
+	autoReshard     int
+	bloomExpected   int
+	memBudget       int64
+	sizeOf          func(V) int64
+	shardMaxEntries int64
+	shardMaxBytes   int64
+	maxTotal        int64
+	rejectOnFull    bool
+	onFull          func(K, V)
+	validator       func(K, V) error
+	copyOnRead      func(V) V
+	versioning      bool
+	onInsert        func(K, V)
+	onUpdate        func(key K, old, new V)
+	onNew           func(DMap[K, V])
+	onPanic         func(recovered any)
+	janitor         time.Duration
+	maxStale        time.Duration
+	refreshAhead    time.Duration
+	clock           Clock
+	flushEvery      time.Duration
+	flush           func([]Entry[K, V]) error
+	eventFlushEvery time.Duration
+}
+
+func newConfig[K comparable, V any](opts []Option[K, V]) *config[K, V] {
+	cfg := &config[K, V]{clock: realClock{}}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// validate checks the config against the shard count of the map.
+func (c *config[K, V]) validate(nShards int) error {
+	if c.weights != nil && (len(c.weights) != nShards || c.weightTable == nil) {
+		return ErrInvalidShardWeights
+	}
+	return nil
+}
+
+// derived returns the config for maps derived from a map with config c.
+// Mutation callbacks are dropped: filling a derived map is a copy of
+// existing data, not a new write the callbacks should hear about.
+// So are background tasks, which only the original map's Close stops.
+func (c *config[K, V]) derived() *config[K, V] {
+	d := *c
+	d.onInsert = nil
+	d.onUpdate = nil
+	d.onNew = nil
+	d.janitor = 0
+	d.flushEvery = 0
+	d.flush = nil
+	d.eventFlushEvery = 0
+	return &d
+}
+
+// convertConfig carries the settings of cfg that do not depend on the
+// value type over to a config for maps with values of type W.
+func convertConfig[K comparable, V, W any](cfg *config[K, V]) *config[K, W] {
+	return &config[K, W]{
+		lockStripes:   cfg.lockStripes,
+		stripeTable:   cfg.stripeTable,
+		lazyShards:    cfg.lazyShards,
+		hasher:        cfg.hasher,
+		keyEncoder:    cfg.keyEncoder,
+		normalizer:    cfg.normalizer,
+		hashSeed:      cfg.hashSeed,
+		weights:       cfg.weights,
+		weightTable:   cfg.weightTable,
+		hitStats:      cfg.hitStats,
+		opStats:       cfg.opStats,
+		fanOutLimit:   cfg.fanOutLimit,
+		replicas:      cfg.replicas,
+		autoReshard:   cfg.autoReshard,
+		bloomExpected: cfg.bloomExpected,
+		maxTotal:      cfg.maxTotal,
+		rejectOnFull:  cfg.rejectOnFull,
+		versioning:    cfg.versioning,
+		maxStale:      cfg.maxStale,
+		clock:         cfg.clock,
+		onPanic:       cfg.onPanic,
+	}
+}
+
+// WithLockStripes makes the map's shards share n locks (lock striping)
+// instead of each shard owning one, with shard i guarded by lock i mod n.
+// This keeps many shards for distribution while bounding the memory
+// spent on locks; shards sharing a lock also contend with each other.
+// Values <= 0 or above the shard count mean one lock per shard.
+func WithLockStripes[K comparable, V any](n int) Option[K, V] {
+	return func(c *config[K, V]) {
+		c.lockStripes = n
+	}
+}
+
+// WithLazyShards defers allocating each shard's inner map until the
+// first write to that shard, which saves memory for sparse maps with
+// many shards.
+func WithLazyShards[K comparable, V any]() Option[K, V] {
+	return func(c *config[K, V]) {
+		c.lazyShards = true
+	}
+}
+
+// WithHashSeed mixes seed into the default placement hash, so maps with
+// different seeds place the same keys on different shards. Placement
+// stays stable for the lifetime of one map.
+// Using a random seed per map (e.g. from crypto/rand) protects against
+// hash flooding, where adversarial keys are crafted to all land on one
+// shard. Without this option maps are unseeded, so placement is
+// reproducible across runs. The seed does not affect WithHasher.
+func WithHashSeed[K comparable, V any](seed uint64) Option[K, V] {
+	return func(c *config[K, V]) {
+		c.hashSeed = seed
+	}
+}
+
+// WithShardWeights places keys on shards in proportion to weights, one
+// weight per shard, instead of uniformly: a shard with twice the weight
+// of another receives about twice as many keys. Weights must not be
+// negative and at least one must be positive; NewE returns
+// ErrInvalidShardWeights otherwise, or if len(weights) differs from the
+// shard count.
+func WithShardWeights[K comparable, V any](weights []int) Option[K, V] {
+	return func(c *config[K, V]) {
+		c.weights = append([]int{}, weights...)
+		c.weightTable = weightTable(weights)
+	}
+}
+
+// weightTable returns a lookup table holding each shard index as many
+// times as its weight (reduced by the weights' gcd), or nil if weights
+// are invalid. Placement is then table[hash mod len(table)].
+func weightTable(weights []int) []int {
+	g := 0
+	for _, w := range weights {
+		if w < 0 {
+			return nil
+		}
+		for b := w; b != 0; {
+			g, b = b, g%b
+		}
+	}
+	if g == 0 {
+		return nil
+	}
+	var table []int
+	for i, w := range weights {
+		for j := 0; j < w/g; j++ {
+			table = append(table, i)
+		}
+	}
+	return table
+}
+
+// WithHitStats makes Get count hits and misses, see HitRatio.
+func WithHitStats[K comparable, V any]() Option[K, V] {
+	return func(c *config[K, V]) {
+		c.hitStats = true
+	}
+}
+
+// WithShardOpStats makes every shard count its reads (Get, Has) and
+// writes (every store or delete of an entry, e.g. Set, Remove), as
+// reported by ShardStats. This reveals access skew that entry counts
+// alone hide, at the cost of an atomic add per operation.
+func WithShardOpStats[K comparable, V any]() Option[K, V] {
+	return func(c *config[K, V]) {
+		c.opStats = true
+	}
+}
+
+// WithFanOutLimit bounds the number of goroutines that Keys, Values,
+// Items and ForEachParallel use to process shards concurrently; the
+// default is GOMAXPROCS. Values <= 0 keep the default.
+func WithFanOutLimit[K comparable, V any](n int) Option[K, V] {
+	return func(c *config[K, V]) {
+		c.fanOutLimit = n
+	}
+}
+
+// WithReplicas keeps n copies of every entry, for testing replication
+// logic in-process: the primary entry on the key's shard, as without the
+// option, plus n-1 replicas of its value on other shards (see
+// GetFromReplica), chosen by successive hashes of the key. n is capped
+// at the shard count. Replicas are updated by every write and removal of
+// the primary entry, while its shard is locked, but are otherwise out of
+// sight: Count, Keys, iteration and the like only see primary entries.
+// Get falls back to the replicas when the primary entry is missing.
+func WithReplicas[K comparable, V any](n int) Option[K, V] {
+	return func(c *config[K, V]) {
+		c.replicas = n
+	}
+}
+
+// WithHasher makes the map place keys on shards by hasher(key) mod the
+// shard count, instead of the default hash.
+func WithHasher[K comparable, V any](hasher func(K) uint64) Option[K, V] {
+	return func(c *config[K, V]) {
+		c.hasher = hasher
+	}
+}
+
+// WithKeyEncoder makes the map place keys on shards by the hash of
+// encode(key) instead of the default hash, which walks composite keys
+// such as structs by reflection. encode should return a compact, stable
+// binary form of the key (e.g. its fields packed in a fixed order) that
+// differs for keys that are not ==; keys with equal encodings still work
+// as separate entries, they only share a shard. The bytes are hashed like
+// the default hash, WithHashSeed included. WithHasher takes precedence.
+func WithKeyEncoder[K comparable, V any](encode func(K) []byte) Option[K, V] {
+	return func(c *config[K, V]) {
+		c.keyEncoder = encode
+	}
+}
+
+// ConstantHasher returns a hasher for WithHasher that maps every key to
+// h, so that all keys land on shard h mod the shard count (shard 0 for
+// h == 0). It is meant for testing behavior under extreme skew, such as
+// a single hot shard.
+func ConstantHasher[K comparable](h uint64) func(K) uint64 {
+	return func(K) uint64 { return h }
+}
+
+// WithMaxTotal caps the total number of entries in the map at n.
+// An insert that takes the map past n evicts an arbitrary entry from the
+// currently fullest shard, which may be a different shard than the one
+// written to (see evictOverflow). Values <= 0 mean no cap.
+func WithMaxTotal[K comparable, V any](n int) Option[K, V] {
+	return func(c *config[K, V]) {
+		c.maxTotal = int64(n)
+	}
+}
+
+// WithRejectOnFull makes a map capped WithMaxTotal reject inserts of new
+// keys once full, instead of evicting, so callers can apply backpressure.
+// A rejected write stores nothing and calls onFull, if not nil, with the
+// rejected key and value under the shard's write lock (so onFull must not
+// access the map). Set and other writers drop the write silently; those
+// that report whether they wrote, such as TrySet, SetIfAbsent,
+// SetIfVersion, Mutate and Rename, report false. Writes to keys already
+// present always succeed.
+func WithRejectOnFull[K comparable, V any](onFull func(K, V)) Option[K, V] {
+	return func(c *config[K, V]) {
+		c.rejectOnFull = true
+		c.onFull = onFull
+	}
+}
+
+// WithKeyNormalizer makes the map store every key as normalize(key), and
+// map every key given to its methods the same way before hashing or
+// lookup, e.g. strings.ToLower for case-insensitive keys: Set("Foo") and
+// Get("foo") then address the same entry. This changes key identity:
+// Keys, ForEach, Subscribe and the like report the normalized keys, and
+// batch writes of keys that normalize alike keep an arbitrary one of
+// their values. normalize must be deterministic and idempotent.
+func WithKeyNormalizer[K comparable, V any](normalize func(K) K) Option[K, V] {
+	return func(c *config[K, V]) {
+		c.normalizer = normalize
+	}
+}
+
+// WithValidator makes writes check each entry with validator first and
+// drop entries for which it returns an error, leaving any existing value
+// in place. Set, SetWithTTL and the batch writes (SetMany, PutAll,
+// AtomicSetAll, ReplaceAll, ...) drop them silently; writers that report
+// whether they wrote, such as TrySet, SetIfAbsent and CompareAndSwap,
+// report false, and SetValidated and GetOrComputeE return the error.
+// validator runs before the shard lock is taken, so it may be slow, but
+// it must be safe for concurrent use. The exceptions are Compute, GetRef,
+// UpdateMany and GetOrCompute, whose values are only known under the
+// lock: there validator runs under it and must not access the map.
+// Entries that move or are reset within the map (Rename, DrainCounters)
+// or are copied to derived maps are not checked again.
+func WithValidator[K comparable, V any](validator func(K, V) error) Option[K, V] {
+	return func(c *config[K, V]) {
+		c.validator = validator
+	}
+}
+
+// WithCopyOnRead makes Get, Values and Items return copyFn(v) instead of
+// the stored value v, so that callers cannot mutate shared state (e.g.
+// through a pointer, slice or map value) outside the shard lock.
+// copyFn runs under the shard's read lock on every such read, so deep
+// copies of large values make reads correspondingly slower. Callbacks
+// such as ForEach's still receive the stored values.
+func WithCopyOnRead[K comparable, V any](copyFn func(V) V) Option[K, V] {
+	return func(c *config[K, V]) {
+		c.copyOnRead = copyFn
+	}
+}
+
+// WithOnInsert registers fn to be called whenever a key that was absent
+// (or expired) is stored in the map.
+// fn runs under the shard's write lock, so it must be quick and must not
+// access the map.
+func WithOnInsert[K comparable, V any](fn func(key K, val V)) Option[K, V] {
+	return func(c *config[K, V]) {
+		c.onInsert = fn
+	}
+}
+
+// WithOnUpdate registers fn to be called whenever the value of a present
+// key is overwritten, with its old and new values.
+// Like WithOnInsert's, fn runs under the shard's write lock.
+func WithOnUpdate[K comparable, V any](fn func(key K, old, new V)) Option[K, V] {
+	return func(c *config[K, V]) {
+		c.onUpdate = fn
+	}
+}
+
+// WithOnNew registers fn to be called with the map once New has built
+// it, e.g. to register it with a metrics registry at construction time
+// rather than in a separate call that might be forgotten (see the
+// dmapprom package). Derived and resharded maps do not call fn.
+func WithOnNew[K comparable, V any](fn func(DMap[K, V])) Option[K, V] {
+	return func(c *config[K, V]) {
+		c.onNew = fn
+	}
+}
+
+// WithRecover makes the map recover panics in the callbacks it runs
+// under a shard lock, pass the recovered value to handler, and fail the
+// operation instead of crashing the goroutine:
+//
+//   - Compute leaves the entry as is and returns its current value;
+//   - GetRef leaves the entry as is;
+//   - GetOrComputeE stores nothing and returns ErrCallbackPanicked (and
+//     GetOrCompute the zero value);
+//   - ForEach stops, and ForEachParallel, Any and All skip the rest of
+//     the shard;
+//   - the WithOnInsert and WithOnUpdate hooks, and the WithRejectOnFull
+//     callback, are skipped, the write itself still happens (or, for a
+//     rejected key, does not);
+//   - a WithValidator that panics rejects the entry, and SetValidated
+//     returns ErrCallbackPanicked;
+//   - CompareAndSwapFunc swaps nothing, UpdateMany leaves the key as is
+//     and SetManyWith keeps the existing value;
+//   - FilterChan closes its channel.
+//
+// In every case the shard lock is released as usual. handler must not
+// access the map. Without WithRecover, such panics propagate.
+func WithRecover[K comparable, V any](handler func(recovered any)) Option[K, V] {
+	return func(c *config[K, V]) {
+		c.onPanic = handler
+	}
+}
+
+// guard calls the user callback fn and reports whether it returned
+// normally. WithRecover, a panic in fn goes to the handler instead of
+// propagating.
+func (c *config[K, V]) guard(fn func()) (ok bool) {
+	if c.onPanic == nil {
+		fn()
+		return true
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			c.onPanic(r)
+		}
+	}()
+	fn()
+	return true
+}
+
+// WithVersioning gives every entry a version, for optimistic concurrency
+// with GetVersioned and SetIfVersion.
+func WithVersioning[K comparable, V any]() Option[K, V] {
+	return func(c *config[K, V]) {
+		c.versioning = true
+	}
+}
+
+// WithJanitor starts a background goroutine that removes expired entries
+// (see SetWithTTL and WithMaxStale) and tombstones (see
+// RemoveWithTombstone) every interval, so they stop taking memory and
+// counting in Count. Stop it with Close.
+func WithJanitor[K comparable, V any](interval time.Duration) Option[K, V] {
+	return func(c *config[K, V]) {
+		c.janitor = interval
+	}
+}
+
+// WithMaxStale bounds how long after expiring an entry is still served,
+// as stale, by GetAllowStale; by default it is served until it is
+// written or removed. WithJanitor, entries are only reaped once they have
+// been expired for d.
+func WithMaxStale[K comparable, V any](d time.Duration) Option[K, V] {
+	return func(c *config[K, V]) {
+		c.maxStale = d
+	}
+}
+
+// WithRefreshAhead makes a Cache reload entries that are read within
+// threshold of their expiry in the background, while the read returns
+// the current value at once, so later reads keep hitting fresh entries
+// instead of missing once the TTL runs out. At most one refresh per key
+// runs at a time; a failed refresh leaves the entry to expire. Plain
+// DMaps, which have no loader, ignore it.
+func WithRefreshAhead[K comparable, V any](threshold time.Duration) Option[K, V] {
+	return func(c *config[K, V]) {
+		c.refreshAhead = threshold
+	}
+}
+
+// WithWriteBehind makes the map write entries behind to a backing store:
+// every interval, a background goroutine passes the current entries of
+// all keys set since the previous flush to flush. Removals are not
+// flushed. If flush fails, its keys are retried on the next flush.
+// Close stops the goroutine and runs a final flush.
+func WithWriteBehind[K comparable, V any](interval time.Duration, flush func([]Entry[K, V]) error) Option[K, V] {
+	return func(c *config[K, V]) {
+		c.flushEvery = interval
+		c.flush = flush
+	}
+}
+
+// WithEventBuffer makes writers queue their events for subscribers (see
+// Subscribe) in a buffer per shard, instead of sending each one while
+// holding the shard lock, and has a background goroutine deliver the
+// buffered events in batches every interval, until Close delivers the
+// rest. This takes the subscriber channels off the write path at the
+// cost of up to interval of latency before events are delivered. Events
+// for one key still arrive in order, and are still dropped when a
+// subscriber's channel is full at delivery time, so size its buffer for
+// a whole interval's writes.
+func WithEventBuffer[K comparable, V any](interval time.Duration) Option[K, V] {
+	return func(c *config[K, V]) {
+		c.eventFlushEvery = interval
+	}
+}
+
+// WithAutoReshard makes a Resizable map grow its shard count whenever the
+// mean number of entries per shard exceeds targetPerShard by a factor of
+// 2, back to at most targetPerShard. It has no effect on a plain DMap,
+// whose shard count is fixed.
+func WithAutoReshard[K comparable, V any](targetPerShard int) Option[K, V] {
+	return func(c *config[K, V]) {
+		c.autoReshard = targetPerShard
+	}
+}
+
+// WithMemoryBudget caps the estimated size of the map's values at bytes,
+// as measured by sizeOf on every store. Writes that take the map over the
+// budget evict the least recently used entries (by Get or write) until
+// it fits again; see evictOverflow for how eviction relates to the write.
+// A value larger than the whole budget is kept, on its own, until
+// replaced or evicted by a later write.
+func WithMemoryBudget[K comparable, V any](bytes int64, sizeOf func(V) int64) Option[K, V] {
+	return func(c *config[K, V]) {
+		c.memBudget = bytes
+		c.sizeOf = sizeOf
+	}
+}
+
+// WithShardLimits caps every shard at maxEntries entries and at maxBytes
+// estimated bytes of values, as measured by sizeOf on every store; values
+// <= 0 mean no such cap. A write that takes its shard over either limit
+// evicts the shard's least recently used entries (by Get or write), other
+// than the key written, until the shard is within both again, before the
+// write returns. Unlike WithMaxTotal and WithMemoryBudget, eviction never
+// leaves the shard written to. sizeOf may be nil without a byte cap; with
+// WithMemoryBudget, the sizeOf of whichever option comes last is used.
+func WithShardLimits[K comparable, V any](maxEntries int, maxBytes int64, sizeOf func(V) int64) Option[K, V] {
+	return func(c *config[K, V]) {
+		c.shardMaxEntries = int64(maxEntries)
+		c.shardMaxBytes = maxBytes
+		if sizeOf != nil {
+			c.sizeOf = sizeOf
+		} else if c.sizeOf == nil {
+			// Entries are still tracked for LRU order.
+			c.sizeOf = func(V) int64 { return 0 }
+		}
+	}
+}
+
+// WithBloomFilter gives every shard a Bloom filter of the keys stored in
+// it, sized for expectedPerShard keys at a 1% false positive rate, which
+// Get and Has consult without locking to answer definite misses. A
+// filter hit (a present key, or a false positive) still takes the normal
+// locked lookup. Removed keys stay in the filter until Clear, so the
+// false positive rate grows with churn and with more keys than expected;
+// it never makes present keys read as absent.
+func WithBloomFilter[K comparable, V any](expectedPerShard int) Option[K, V] {
+	return func(c *config[K, V]) {
+		c.bloomExpected = expectedPerShard
+	}
+}
+
+// Clock is a source of the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// WithClock makes the map read the current time from clock, for
+// expiries (TTLs, negative caching, tombstones) and IncrementWindow,
+// instead of from time.Now; e.g. tests can pass a fake clock and advance
+// it instead of sleeping. Background tasks still run on real-time
+// tickers (see WithJanitor), but judge expiry by clock.
+func WithClock[K comparable, V any](clock Clock) Option[K, V] {
+	return func(c *config[K, V]) {
+		if clock != nil {
+			c.clock = clock
+		}
+	}
+}
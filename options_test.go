@@ -0,0 +1,417 @@
+package dmap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithLockStripes(t *testing.T) {
+	m := New[string, int](64, WithLockStripes[string, int](4))
+	require.Equal(t, 64, len(m))
+	require.Same(t, m[0].mu, m[4].mu)
+	require.NotSame(t, m[0].mu, m[1].mu)
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < 8; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				m.Set(fmt.Sprintf("key_%d_%d", w, i), i)
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				m.Get(fmt.Sprintf("key_%d_%d", w, i))
+				m.Count()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	require.EqualValues(t, 8*500, m.Count())
+	require.Len(t, m.Keys(), 8*500)
+	for w := 0; w < 8; w++ {
+		for i := 0; i < 500; i++ {
+			v, ok := m.Get(fmt.Sprintf("key_%d_%d", w, i))
+			require.True(t, ok)
+			require.Equal(t, i, v)
+		}
+	}
+}
+
+func TestWithLockStripesOutOfRange(t *testing.T) {
+	for _, n := range []int{-1, 0, 100} {
+		m := New[string, int](8, WithLockStripes[string, int](n))
+		for i := 1; i < len(m); i++ {
+			require.NotSame(t, m[0].mu, m[i].mu)
+		}
+	}
+}
+
+func TestWithLazyShards(t *testing.T) {
+	m := New[string, int](10000, WithLazyShards[string, int]())
+	for _, shard := range m {
+		require.Nil(t, shard.items)
+	}
+
+	written := map[int]bool{}
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("key_%d", i)
+		require.False(t, m.Has(key))
+		_, ok := m.Get(key)
+		require.False(t, ok)
+
+		m.Set(key, i)
+		written[m.getShardIndex(key)] = true
+	}
+	for i, shard := range m {
+		if !written[i] {
+			require.Nil(t, shard.items, "shard %d", i)
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		v, ok := m.Get(fmt.Sprintf("key_%d", i))
+		require.True(t, ok)
+		require.Equal(t, i, v)
+	}
+	require.EqualValues(t, 5, m.Count())
+	require.Len(t, m.Keys(), 5)
+	m.Remove("key_0")
+	m.Remove("missing")
+	require.EqualValues(t, 4, m.Count())
+}
+
+func TestWithLazyShardsConcurrentFirstWrite(t *testing.T) {
+	m := New[string, int](4, WithLazyShards[string, int]())
+	wg := sync.WaitGroup{}
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				m.Set(fmt.Sprintf("key_%d_%d", w, i), i)
+			}
+		}(w)
+	}
+	wg.Wait()
+	require.EqualValues(t, 800, m.Count())
+}
+
+func TestWithCopyOnRead(t *testing.T) {
+	copySlice := func(v []int) []int { return append([]int(nil), v...) }
+	m := New[string, []int](4, WithCopyOnRead[string](copySlice))
+	m.Set("a", []int{1, 2, 3})
+
+	got, _ := m.Get("a")
+	got[0] = 100
+	m.Values()[0][1] = 100
+	m.Items()["a"][2] = 100
+
+	stored, _ := m.Get("a")
+	require.Equal(t, []int{1, 2, 3}, stored)
+
+	// Without the option callers share the stored slice.
+	shared := New[string, []int](4)
+	shared.Set("a", []int{1, 2, 3})
+	got, _ = shared.Get("a")
+	got[0] = 100
+	stored, _ = shared.Get("a")
+	require.Equal(t, []int{100, 2, 3}, stored)
+}
+
+func TestWithKeyNormalizer(t *testing.T) {
+	m := New[string, int](16, WithKeyNormalizer[string, int](strings.ToLower))
+
+	m.Set("Foo", 1)
+	v, ok := m.Get("foo")
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+	require.True(t, m.Has("FOO"))
+	require.Equal(t, m.ShardIndex("foo"), m.ShardIndex("fOO"))
+	m.Set("FOO", 2)
+	require.EqualValues(t, 1, m.Count())
+	require.Equal(t, []string{"foo"}, m.Keys())
+	v, _ = m.Get("Foo")
+	require.Equal(t, 2, v)
+
+	m.SetMany(map[string]int{"Bar": 3, "BAZ": 4})
+	require.Equal(t, map[string]int{"bar": 3, "baz": 4, "foo": 2},
+		m.GetMany([]string{"BAR", "baz", "Foo", "missing"}))
+	require.True(t, m.Rename("BAZ", "Qux"))
+	require.True(t, m.Has("qux"))
+
+	m.Remove("fOo")
+	require.False(t, m.Has("foo"))
+	require.ElementsMatch(t, []string{"bar", "qux"}, m.Keys())
+
+	// Derived maps keep normalizing.
+	c := m.Clone()
+	require.True(t, c.Has("BAR"))
+}
+
+func TestWithValidator(t *testing.T) {
+	errNegative := errors.New("negative value")
+	m := New[string, int](4, WithValidator(func(_ string, v int) error {
+		if v < 0 {
+			return errNegative
+		}
+		return nil
+	}))
+
+	require.NoError(t, m.SetValidated("a", 1))
+	require.ErrorIs(t, m.SetValidated("a", -1), errNegative)
+	v, _ := m.Get("a")
+	require.Equal(t, 1, v, "rejected writes keep the old value")
+
+	m.Set("b", -2)
+	m.SetWithTTL("c", -3, time.Hour)
+	require.False(t, m.TrySet("d", -4))
+	require.True(t, m.TrySet("d", 4))
+	require.False(t, m.Has("b"))
+	require.False(t, m.Has("c"))
+
+	created := m.PutAll(map[string]int{"e": 5, "f": -6})
+	require.Equal(t, []string{"e"}, created)
+	m.SetMany(map[string]int{"g": -7, "h": 8})
+	require.ElementsMatch(t, []string{"a", "d", "e", "h"}, m.Keys())
+
+	// Every other write path checks too.
+	m.AtomicSetAll(map[string]int{"a": -1, "i": 9})
+	require.False(t, m.SetIfAbsent("j", -10))
+	_, loaded := m.Swap("a", -1)
+	require.False(t, loaded)
+	require.False(t, m.CompareAndSwapFunc("a", 1, -1, func(x, y int) bool { return x == y }))
+	require.False(t, CompareAndSwap(m, "a", 1, -1))
+	require.Equal(t, 1, m.Compute("a", func(int, bool) int { return -1 }))
+	require.True(t, m.GetRef("a", func(v *int) bool { *v = -1; return true }))
+	require.Equal(t, 1, m.UpdateMany(map[string]func(int) int{
+		"a": func(int) int { return -1 },
+		"d": func(int) int { return 40 },
+	}))
+	_, err := m.GetOrComputeE("k", func() (int, error) { return -11, nil })
+	require.ErrorIs(t, err, errNegative)
+	m.SetWithRoute("a", "l", -12)
+	_, ok := m.GetWithRoute("a", "l")
+	require.False(t, ok)
+	require.Equal(t, map[string]int{"a": 1, "d": 40, "e": 5, "h": 8, "i": 9}, m.Items())
+
+	m.ReplaceAll(map[string]int{"a": -1, "z": 26})
+	require.Equal(t, map[string]int{"z": 26}, m.Items())
+
+	versioned := New[string, int](4, WithVersioning[string, int](),
+		WithValidator(func(_ string, v int) error {
+			if v < 0 {
+				return errNegative
+			}
+			return nil
+		}))
+	version, ok := versioned.SetIfVersion("a", -1, 0)
+	require.False(t, ok)
+	require.Zero(t, version)
+}
+
+func TestWithRecover(t *testing.T) {
+	var recovered []any
+	m := New[string, int](4,
+		WithRecover[string, int](func(r any) { recovered = append(recovered, r) }),
+		WithOnInsert[string, int](func(k string, _ int) {
+			if k == "bad insert" {
+				panic("insert hook")
+			}
+		}))
+	m.Set("a", 1)
+
+	require.Equal(t, 1, m.Compute("a", func(int, bool) int { panic("compute") }))
+	require.True(t, m.GetRef("a", func(*int) bool { panic("getref") }))
+	_, err := m.GetOrComputeE("b", func() (int, error) { panic("getorcompute") })
+	require.ErrorIs(t, err, ErrCallbackPanicked)
+	require.Zero(t, m.GetOrCompute("b", func() int { panic("getorcompute") }))
+	require.False(t, m.Has("b"))
+	calls := 0
+	m.ForEach(func(string, int) bool {
+		calls++
+		panic("foreach")
+	})
+	require.Equal(t, 1, calls)
+	m.ForEachParallel(func(string, int) { panic("foreachparallel") })
+	require.False(t, m.Any(func(string, int) bool { panic("any") }))
+	m.Set("bad insert", 2)
+	require.Equal(t, []any{"compute", "getref", "getorcompute", "getorcompute",
+		"foreach", "foreachparallel", "any", "insert hook"}, recovered)
+
+	// No lock was left held, and failed operations wrote nothing.
+	v, _ := m.Get("a")
+	require.Equal(t, 1, v)
+	require.True(t, m.Has("bad insert"))
+	require.Equal(t, 2, m.Compute("a", func(old int, _ bool) int { return old + 1 }))
+	m.Clear()
+	require.Zero(t, m.Count())
+
+	// Without WithRecover, panics propagate.
+	plain := New[string, int](4)
+	require.PanicsWithValue(t, "compute", func() {
+		plain.Compute("a", func(int, bool) int { panic("compute") })
+	})
+	plain.Set("a", 1)
+
+	// Nor do they leave the shard locked.
+	hooked := New[string, int](1,
+		WithOnInsert[string, int](func(k string, _ int) {
+			if k == "bad insert" {
+				panic("insert hook")
+			}
+		}))
+	require.PanicsWithValue(t, "insert hook", func() { hooked.Set("bad insert", 1) })
+	require.PanicsWithValue(t, "getref", func() {
+		hooked.GetRef("bad insert", func(*int) bool { panic("getref") })
+	})
+	require.PanicsWithValue(t, "eq", func() {
+		hooked.CompareAndSwapFunc("bad insert", 1, 2, func(int, int) bool { panic("eq") })
+	})
+	hooked.Set("a", 1)
+	v, ok := hooked.Get("a")
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+}
+
+func TestWithRecoverGuardsValidatorAndOnFull(t *testing.T) {
+	var recovered []any
+	m := New[string, int](1,
+		WithRecover[string, int](func(r any) { recovered = append(recovered, r) }),
+		WithMaxTotal[string, int](1),
+		WithRejectOnFull[string, int](func(string, int) { panic("onfull") }),
+		WithValidator[string, int](func(_ string, v int) error {
+			if v < 0 {
+				panic("validator")
+			}
+			return nil
+		}))
+	require.ErrorIs(t, m.SetValidated("a", -1), ErrCallbackPanicked)
+	m.Set("a", 1)
+	require.False(t, m.TrySet("b", 2))
+	require.False(t, m.CompareAndSwapFunc("a", 1, 2, func(int, int) bool { panic("eq") }))
+	require.Equal(t, []any{"validator", "onfull", "eq"}, recovered)
+	v, _ := m.Get("a")
+	require.Equal(t, 1, v)
+}
+
+func TestWithOnInsertAndOnUpdate(t *testing.T) {
+	var inserts, updates []string
+	m := New[string, int](4,
+		WithOnInsert(func(k string, v int) {
+			inserts = append(inserts, fmt.Sprintf("%s=%d", k, v))
+		}),
+		WithOnUpdate(func(k string, old, new int) {
+			updates = append(updates, fmt.Sprintf("%s:%d->%d", k, old, new))
+		}),
+	)
+
+	m.Set("a", 1)
+	require.Equal(t, []string{"a=1"}, inserts)
+	require.Empty(t, updates)
+
+	m.Set("a", 2)
+	require.Equal(t, []string{"a=1"}, inserts)
+	require.Equal(t, []string{"a:1->2"}, updates)
+
+	m.Compute("a", func(old int, _ bool) int { return old * 10 })
+	m.SetMany(map[string]int{"b": 3})
+	require.Equal(t, []string{"a=1", "b=3"}, inserts)
+	require.Equal(t, []string{"a:1->2", "a:2->20"}, updates)
+
+	// Derived maps do not fire the source's callbacks.
+	m.Clone().Set("c", 4)
+	require.Len(t, inserts, 2)
+}
+
+func TestHooksAreOptional(t *testing.T) {
+	m := New[string, int](4, WithOnUpdate[string, int](nil))
+	m.Set("a", 1)
+	m.Set("a", 2)
+}
+
+func TestWithShardWeights(t *testing.T) {
+	m := New[string, int](4, WithShardWeights[string, int]([]int{10, 10, 20, 0}))
+	for i := 0; i < 40000; i++ {
+		m.Set(fmt.Sprintf("key_%d", i), i)
+	}
+
+	counts := make([]int, len(m))
+	for i, shard := range m {
+		counts[i] = len(shard.items)
+	}
+	require.Zero(t, counts[3])
+	for _, light := range counts[:2] {
+		ratio := float64(counts[2]) / float64(light)
+		require.InDelta(t, 2.0, ratio, 0.15, "counts %v", counts)
+	}
+	v, ok := m.Get("key_123")
+	require.True(t, ok)
+	require.Equal(t, 123, v)
+}
+
+func TestWithShardWeightsInvalid(t *testing.T) {
+	for _, weights := range [][]int{{1, 2}, {1, 2, 3, 4, 5}, {1, -1, 1, 1}, {0, 0, 0, 0}, {}} {
+		_, err := NewE[string, int](4, WithShardWeights[string, int](weights))
+		require.ErrorIs(t, err, ErrInvalidShardWeights, "weights %v", weights)
+	}
+}
+
+func TestWeightTable(t *testing.T) {
+	require.Equal(t, []int{0, 1, 2, 2}, weightTable([]int{10, 10, 20}))
+	require.Equal(t, []int{1}, weightTable([]int{0, 3}))
+	require.Nil(t, weightTable([]int{0, 0}))
+}
+
+func TestConstantHasher(t *testing.T) {
+	m := New[string, int](8,
+		WithHasher[string, int](ConstantHasher[string](0)),
+		WithMaxTotal[string, int](500))
+	for i := 0; i < 1000; i++ {
+		m.Set(fmt.Sprintf("key_%d", i), i)
+		require.Zero(t, m.ShardIndex(fmt.Sprintf("key_%d", i)))
+	}
+
+	require.EqualValues(t, 500, m.Count())
+	require.Len(t, m[0].items, 500)
+	for _, shard := range m[1:] {
+		require.Empty(t, shard.items)
+	}
+	stats := m.Stats()
+	require.EqualValues(t, 500, stats.MaxShardCount)
+	require.Zero(t, stats.MinShardCount)
+
+	// The most recent insert is never the eviction victim.
+	v, ok := m.Get("key_999")
+	require.True(t, ok)
+	require.Equal(t, 999, v)
+	m.Remove("key_999")
+	require.False(t, m.Has("key_999"))
+	require.Len(t, m.Keys(), 499)
+	require.True(t, m.Rename(m.Keys()[0], "renamed"))
+	require.True(t, m.Has("renamed"))
+
+	other := New[string, int](8, WithHasher[string, int](ConstantHasher[string](11)))
+	require.Equal(t, 3, other.ShardIndex("any"))
+}
+
+func TestWithOnNew(t *testing.T) {
+	var built []DMap[string, int]
+	m := New[string, int](4, WithOnNew(func(m DMap[string, int]) { built = append(built, m) }))
+	require.Len(t, built, 1)
+	require.Equal(t, m[0], built[0][0], "called with the map itself")
+
+	m.Clone()
+	_, err := m.Reshard(8)
+	require.NoError(t, err)
+	require.Len(t, built, 1, "derived and resharded maps do not call fn")
+}
@@ -0,0 +1,67 @@
+package dmap
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Pool recycles DMaps with a fixed number of shards, for services that
+// create and discard many short-lived maps (e.g. per-request caches).
+// A Pool is safe for concurrent use.
+type Pool[K comparable, V any] struct {
+	nShards int
+	// cfg is the default configuration, shared by all maps of the pool,
+	// so that Put can tell them from maps built elsewhere.
+	cfg *config[K, V]
+	// pool holds the states of the free maps rather than the maps
+	// themselves: a DMap is a slice, which would be boxed on every Put,
+	// while a state is a pointer that refers back to its map.
+	pool sync.Pool
+}
+
+// NewPool creates a Pool of DMaps with nShards shards each, built without
+// options. It panics like New if nShards < 1.
+func NewPool[K comparable, V any](nShards int) *Pool[K, V] {
+	if nShards < 1 {
+		panic(ErrInvalidShardCount)
+	}
+	p := &Pool[K, V]{nShards: nShards, cfg: newConfig[K, V](nil)}
+	p.pool.New = func() any {
+		m := newWithConfig(nShards, p.cfg)
+		m.state().shards = m
+		return m.state()
+	}
+	return p
+}
+
+// Get returns an empty DMap from the pool, allocating one if none is free.
+func (p *Pool[K, V]) Get() DMap[K, V] {
+	return p.pool.Get().(*state[K, V]).shards
+}
+
+// Put clears m, ends its subscriptions, zeroes its statistics and returns
+// it to the pool. Only maps handed out by the pool's Get are kept: others,
+// which may have a different number of shards or have been built with
+// options the pool's maps lack, are dropped, as are frozen or closed maps,
+// which cannot be reused.
+//
+// m must not be used after Put: it may be handed out again by Get at any
+// time, and any later reads or writes through m would see, or corrupt,
+// another user's data.
+func (p *Pool[K, V]) Put(m DMap[K, V]) {
+	if len(m) != p.nShards || m.config() != p.cfg {
+		return
+	}
+	st := m.state()
+	if st.isFrozen() || st.isClosed() {
+		return
+	}
+	m.Clear()
+	st.cancelSubscriptions()
+	m.ResetStats()
+	for _, shard := range m {
+		atomic.StoreInt64(&shard.reads, 0)
+		atomic.StoreInt64(&shard.writes, 0)
+	}
+	p.pool.Put(st)
+}
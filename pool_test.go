@@ -0,0 +1,100 @@
+package dmap
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPool(t *testing.T) {
+	p := NewPool[string, int](10)
+
+	m := p.Get()
+	require.Equal(t, 10, len(m))
+	for i := 0; i < 100; i++ {
+		m.Set(fmt.Sprintf("key_%d", i), i)
+	}
+	p.Put(m)
+
+	m = p.Get()
+	require.EqualValues(t, 0, m.Count())
+	require.False(t, m.Has("key_1"))
+	m.Set("foo", 1)
+	v, ok := m.Get("foo")
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+}
+
+func TestPoolDropsMismatchedMaps(t *testing.T) {
+	p := NewPool[string, int](10)
+	p.Put(New[string, int](3))
+
+	require.Equal(t, 10, len(p.Get()))
+}
+
+func TestPoolDropsConfiguredMaps(t *testing.T) {
+	p := NewPool[string, int](10)
+	p.Put(New[string, int](10, WithMaxTotal[string, int](1)))
+
+	m := p.Get()
+	for i := 0; i < 100; i++ {
+		m.Set(fmt.Sprintf("key_%d", i), i)
+	}
+	require.EqualValues(t, 100, m.Count(), "a map from Get has no options")
+}
+
+func TestPoolResetsState(t *testing.T) {
+	p := NewPool[string, int](10)
+	m := p.Get()
+	events, _ := m.Subscribe(1)
+	m.Get("missing")
+	p.Put(m)
+	_, open := <-events
+	require.False(t, open, "subscriptions end on Put")
+
+	m = p.Get()
+	require.Zero(t, m.Stats().Misses)
+}
+
+func TestPoolDropsFrozenAndClosedMaps(t *testing.T) {
+	p := NewPool[string, int](10)
+	frozen := p.Get()
+	frozen.Set("a", 1)
+	frozen.Freeze()
+	closed := p.Get()
+	require.NoError(t, closed.Close())
+
+	require.NotPanics(t, func() {
+		p.Put(frozen)
+		p.Put(closed)
+	})
+	for i := 0; i < 3; i++ {
+		m := p.Get()
+		require.False(t, m.IsFrozen())
+		require.False(t, m.IsClosed())
+	}
+	require.True(t, frozen.Has("a"))
+}
+
+func benchmarkShortLivedMaps(b *testing.B, get func() DMap[string, int], put func(DMap[string, int])) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m := get()
+		for j := 0; j < 10; j++ {
+			m.Set(keys[j], j)
+		}
+		put(m)
+	}
+}
+
+func BenchmarkNewShortLived(b *testing.B) {
+	benchmarkShortLivedMaps(b,
+		func() DMap[string, int] { return New[string, int](10) },
+		func(DMap[string, int]) {})
+}
+
+func BenchmarkPoolShortLived(b *testing.B) {
+	p := NewPool[string, int](10)
+	benchmarkShortLivedMaps(b, p.Get, p.Put)
+}
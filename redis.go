@@ -0,0 +1,31 @@
+package dmap
+
+// Redis-style names for existing operations, for code ported from
+// Redis. Each is a thin wrapper around the method it names.
+
+// SetNX is SetIfAbsent: it sets key to val only if key is absent, and
+// reports whether it did.
+func (m DMap[K, V]) SetNX(key K, val V) bool {
+	return m.SetIfAbsent(key, val)
+}
+
+// GetSet is Swap: it sets key to val and returns the previous value, with
+// loaded reporting whether key was present.
+func (m DMap[K, V]) GetSet(key K, val V) (old V, loaded bool) {
+	return m.Swap(key, val)
+}
+
+// Exists is Has.
+func (m DMap[K, V]) Exists(key K) bool {
+	return m.Has(key)
+}
+
+// IncrBy is Increment.
+func IncrBy[K comparable](m DMap[K, int64], key K, delta int64) int64 {
+	return Increment(m, key, delta)
+}
+
+// DecrBy is Decrement.
+func DecrBy[K comparable](m DMap[K, int64], key K, delta int64) int64 {
+	return Decrement(m, key, delta)
+}
@@ -0,0 +1,26 @@
+package dmap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisAliases(t *testing.T) {
+	m := New[string, int64](4)
+	require.True(t, m.SetNX("a", 1))
+	require.False(t, m.SetNX("a", 2))
+	require.True(t, m.Exists("a"))
+	require.False(t, m.Exists("b"))
+
+	old, loaded := m.GetSet("a", 5)
+	require.True(t, loaded)
+	require.EqualValues(t, 1, old)
+	old, loaded = m.GetSet("b", 7)
+	require.False(t, loaded)
+	require.Zero(t, old)
+
+	require.EqualValues(t, 8, IncrBy(m, "a", 3))
+	require.EqualValues(t, 6, DecrBy(m, "a", 2))
+	require.EqualValues(t, -1, DecrBy(m, "c", 1))
+}
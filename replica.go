@@ -0,0 +1,115 @@
+package dmap
+
+// replicaSeed seeds the hash that picks a key's replica shards, so that
+// it is independent of shard placement and of the Bloom filter's hash.
+const replicaSeed = 0xc2b2ae3d27d4eb4f
+
+// replicaIndices returns the indices of the shards holding the replicas
+// of key WithReplicas, in replica order: one hash of the key picks the
+// first, and each further replica takes a fresh hash of the previous
+// one, probing forward past the primary and shards already taken.
+func (m DMap[K, V]) replicaIndices(key K) []int {
+	n := m.config().replicas
+	if n > len(m) {
+		n = len(m)
+	}
+	if n <= 1 {
+		return nil
+	}
+	taken := make(map[int]bool, n)
+	taken[m.getShardIndex(key)] = true
+	indices := make([]int, 0, n-1)
+	h := hashKeySeed(key, replicaSeed)
+	for len(indices) < n-1 {
+		i := int(h % uint64(len(m)))
+		for taken[i] {
+			i = (i + 1) % len(m)
+		}
+		taken[i] = true
+		indices = append(indices, i)
+		h = hashUint64(h, uint64(i))
+	}
+	return indices
+}
+
+// GetFromReplica returns the value of key as held by one of its copies
+// WithReplicas: replica 0 is the primary entry, as read by Get without
+// fallback, and 1 to n-1 are the copies on other shards. ok is false if
+// that copy is missing or replica is out of range. Replicas hold values
+// only, so an expired primary entry may still have live replicas until
+// it is removed.
+func (m DMap[K, V]) GetFromReplica(key K, replica int) (V, bool) {
+	var zero V
+	key = m.normalize(key)
+	if replica == 0 {
+		shard := m.getShard(key)
+		shard.mu.RLock()
+		defer shard.mu.RUnlock()
+		v, ok := shard.lookup(key)
+		return shard.readCopy(v), ok
+	}
+	indices := m.replicaIndices(key)
+	if replica < 0 || replica > len(indices) {
+		return zero, false
+	}
+	return m[indices[replica-1]].replica(key)
+}
+
+// DropPrimary removes the primary entry of key, leaving its copies
+// WithReplicas in place, and reports whether it was present, e.g. to test
+// failover: Get and Has then fall back to the replicas until key is
+// written again, which restores the primary entry, or removed with
+// Remove, which removes the replicas too. Like a lost shard, the dropped
+// entry no longer appears in Count, Keys or iteration, and no event is
+// published. Without WithReplicas, the key is simply gone.
+func (m DMap[K, V]) DropPrimary(key K) bool {
+	key = m.normalize(key)
+	shard := m.getShard(key)
+	if !shard.lockWrite() {
+		return false
+	}
+	defer shard.mu.Unlock()
+	_, ok := shard.removePrimary(key)
+	return ok
+}
+
+// getReplica returns the value of key from the first of its replicas
+// that has one.
+func (m DMap[K, V]) getReplica(key K) (V, bool) {
+	for _, i := range m.replicaIndices(key) {
+		if v, ok := m[i].replica(key); ok {
+			return v, true
+		}
+	}
+	var zero V
+	return zero, false
+}
+
+// replica returns the copy of key held by the shard.
+func (s *Shard[K, V]) replica(key K) (V, bool) {
+	s.replicaMu.Lock()
+	defer s.replicaMu.Unlock()
+	v, ok := s.replicas[key]
+	return s.readCopy(v), ok
+}
+
+// replicate stores val in the replica shards of key, or removes key from
+// them if remove is set. It only takes the replica shards' replicaMu,
+// which is never held while acquiring another lock, so it is safe to
+// call with a shard lock held.
+func (s *Shard[K, V]) replicate(key K, val V, remove bool) {
+	m := s.state.shards
+	for _, i := range m.replicaIndices(key) {
+		r := m[i]
+		r.replicaMu.Lock()
+		if remove {
+			delete(r.replicas, key)
+		} else {
+			if r.replicas == nil {
+				r.replicas = make(map[K]V)
+			}
+			r.replicas[key] = val
+		}
+		r.replicaMu.Unlock()
+	}
+}
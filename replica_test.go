@@ -0,0 +1,108 @@
+package dmap
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithReplicas(t *testing.T) {
+	m := New[string, int](8, WithReplicas[string, int](3))
+	for i := 0; i < 100; i++ {
+		m.Set(fmt.Sprintf("key_%d", i), i)
+	}
+	require.EqualValues(t, 100, m.Count(), "replicas are not counted")
+	require.Len(t, m.Keys(), 100)
+
+	indices := m.replicaIndices("key_7")
+	require.Len(t, indices, 2)
+	require.NotContains(t, indices, m.ShardIndex("key_7"))
+	require.NotEqual(t, indices[0], indices[1])
+	for r := 0; r < 3; r++ {
+		v, ok := m.GetFromReplica("key_7", r)
+		require.True(t, ok, "replica %d", r)
+		require.Equal(t, 7, v)
+	}
+	_, ok := m.GetFromReplica("key_7", 3)
+	require.False(t, ok)
+
+	// Writes reach the replicas.
+	m.Set("key_7", 70)
+	v, _ := m.GetFromReplica("key_7", 2)
+	require.Equal(t, 70, v)
+
+	// Losing the primary entry leaves the replicas readable, and Get
+	// and Has fall back to them.
+	require.True(t, m.DropPrimary("key_7"))
+	require.False(t, m.DropPrimary("key_7"))
+	require.EqualValues(t, 99, m.Count())
+	_, ok = m.GetFromReplica("key_7", 0)
+	require.False(t, ok)
+	v, ok = m.GetFromReplica("key_7", 1)
+	require.True(t, ok)
+	require.Equal(t, 70, v)
+	v, ok = m.Get("key_7")
+	require.True(t, ok)
+	require.Equal(t, 70, v)
+	require.True(t, m.Has("key_7"))
+
+	// Writing the key restores the primary entry.
+	m.Set("key_7", 71)
+	v, ok = m.GetFromReplica("key_7", 0)
+	require.True(t, ok)
+	require.Equal(t, 71, v)
+	require.EqualValues(t, 100, m.Count())
+
+	// Removing a dropped entry removes its replicas.
+	m.DropPrimary("key_9")
+	m.Remove("key_9")
+	require.False(t, m.Has("key_9"))
+	_, ok = m.GetFromReplica("key_9", 1)
+	require.False(t, ok)
+
+	// Removing the primary entry removes its replicas.
+	m.Remove("key_8")
+	for r := 0; r < 3; r++ {
+		_, ok := m.GetFromReplica("key_8", r)
+		require.False(t, ok)
+	}
+	_, ok = m.Get("key_8")
+	require.False(t, ok)
+
+	m.ReplaceAll(map[string]int{"fresh": 1})
+	_, ok = m.GetFromReplica("key_7", 1)
+	require.False(t, ok)
+	v, ok = m.GetFromReplica("fresh", 2)
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+
+	m.Clear()
+	_, ok = m.GetFromReplica("fresh", 2)
+	require.False(t, ok)
+}
+
+func TestReplicaSeed(t *testing.T) {
+	seeds := map[uint64]string{}
+	for name, seed := range map[string]uint64{
+		"bloom": bloomSeed, "split": splitSeed, "replica": replicaSeed,
+	} {
+		require.NotContains(t, seeds, seed, "%s reuses the seed of %s", name, seeds[seed])
+		seeds[seed] = name
+	}
+}
+
+func TestWithReplicasCapped(t *testing.T) {
+	m := New[int, int](2, WithReplicas[int, int](5))
+	m.Set(1, 1)
+	require.Len(t, m.replicaIndices(1), 1)
+	v, ok := m.GetFromReplica(1, 1)
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+
+	plain := New[int, int](4)
+	plain.Set(1, 1)
+	require.Nil(t, plain.replicaIndices(1))
+	_, ok = plain.GetFromReplica(1, 1)
+	require.False(t, ok)
+}
@@ -0,0 +1,344 @@
+package dmap
+
+import (
+	"context"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reshard returns a new DMap holding the entries of m, expiries
+// included, spread over n shards. Options are carried over as for
+// derived maps (see Filter). Reshard reads m one shard at a time, so
+// writes to m while it runs may or may not be reflected in the result.
+// It returns ErrInvalidShardCount if n < 1, and ErrInvalidShardWeights
+// if m was built WithShardWeights for a different shard count.
+func (m DMap[K, V]) Reshard(n int) (DMap[K, V], error) {
+	return m.ReshardWithProgress(context.Background(), n, nil)
+}
+
+// reshardChunk is how many entries ReshardWithProgress migrates between
+// progress reports.
+const reshardChunk = 1024
+
+// ReshardWithProgress is like Reshard, for large maps: it migrates the
+// entries in chunks, yielding to the scheduler and calling progress (if
+// not nil) with the number of entries migrated so far and the total after
+// each chunk, and stops with ctx.Err() once ctx is done. Every shard of m
+// is copied out (under its read lock) before migration starts, so the
+// total is fixed and memory use peaks at about twice the map's entries.
+func (m DMap[K, V]) ReshardWithProgress(ctx context.Context, n int, progress func(done, total int)) (DMap[K, V], error) {
+	if n < 1 {
+		return nil, ErrInvalidShardCount
+	}
+	cfg := m.config().derived()
+	if err := cfg.validate(n); err != nil {
+		return nil, err
+	}
+
+	type migrated struct {
+		key      K
+		val      V
+		expireAt time.Time
+		expires  bool
+		route    K
+		routed   bool
+	}
+	var entries []migrated
+	routes := m.routeTable()
+	for _, shard := range m {
+		shard.mu.RLock()
+		now := shard.now()
+		for k, v := range shard.items {
+			if shard.expiredAt(k, now) || !m.placedOn(routes, k, shard) {
+				continue
+			}
+			expireAt, expires := shard.expires[k]
+			r, routed := routes[k]
+			entries = append(entries, migrated{k, v, expireAt, expires, r.key, routed})
+		}
+		shard.mu.RUnlock()
+	}
+
+	out := newWithConfig(n, cfg)
+	if routes != nil {
+		out.state().routing = 1
+		out.state().routes = make(map[K]placement[K, V])
+	}
+	for done := 0; ; {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		end := done + reshardChunk
+		if end > len(entries) {
+			end = len(entries)
+		}
+		for _, e := range entries[done:end] {
+			dst := out.getShard(e.key)
+			if e.routed {
+				dst = out.getShard(e.route)
+			}
+			stored, _ := dst.set(e.key, e.val)
+			if !stored {
+				continue
+			}
+			if e.expires {
+				dst.setExpireAt(e.key, e.expireAt)
+			}
+			if e.routed {
+				out.state().routes[e.key] = placement[K, V]{e.route, dst}
+			}
+		}
+		done = end
+		if progress != nil {
+			progress(done, len(entries))
+		}
+		if done == len(entries) {
+			break
+		}
+		runtime.Gosched()
+	}
+	return out, nil
+}
+
+// Rebalance evens out the load on the locks of a map built
+// WithLockStripes, without changing its shard count or key placement.
+// Striped shards are assigned to locks round-robin on construction, so a
+// few shards that grew large can end up sharing a lock. Rebalance returns
+// a new map holding the entries of m, expiries included, with the shards
+// reassigned to locks by their current entry counts, largest first, each
+// to the lock with the fewest entries so far. The assignment sticks to
+// maps derived from the result (see Filter) with the same shard count.
+//
+// The result takes m's place, like a grown Resizable map: it keeps all
+// options of m, hooks and background goroutines included, and its
+// subscriptions, while m's background goroutines stop. Use the result
+// from then on; writes to m while Rebalance runs, or after, may be lost.
+//
+// Placement itself is a pure function of the key, also WithShardWeights,
+// so there is nothing else to rebalance: for maps where each shard has
+// its own lock, Rebalance returns m itself. Like Reshard, it reads m one
+// shard at a time.
+func (m DMap[K, V]) Rebalance() DMap[K, V] {
+	if stripes := m.config().lockStripes; stripes <= 0 || stripes >= len(m) {
+		return m
+	}
+	cfg := m.config().derived()
+	cfg.stripeTable = m.balancedStripes()
+	out := newWithConfig(len(m), cfg)
+	routes := m.routeTable()
+	for i, shard := range m {
+		dst := out[i]
+		shard.mu.RLock()
+		now := shard.now()
+		for k, v := range shard.items {
+			if shard.expiredAt(k, now) || !m.placedOn(routes, k, shard) {
+				continue
+			}
+			if stored, _ := dst.set(k, v); !stored {
+				continue
+			}
+			if expireAt, ok := shard.expires[k]; ok {
+				dst.setExpireAt(k, expireAt)
+			}
+		}
+		shard.mu.RUnlock()
+	}
+	carryRoutes(m, routes, out)
+	m.handOver(out)
+	return out
+}
+
+// balancedStripes assigns the shards of m to its lock stripes greedily by
+// entry count and returns the stripe of each shard index.
+func (m DMap[K, V]) balancedStripes() []int {
+	counts := make([]int64, len(m))
+	order := make([]int, len(m))
+	for i, shard := range m {
+		shard.mu.RLock()
+		counts[i] = shard.count
+		shard.mu.RUnlock()
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return counts[order[a]] > counts[order[b]] })
+	loads := make([]int64, m.config().lockStripes)
+	table := make([]int, len(m))
+	for _, i := range order {
+		least := 0
+		for s := range loads {
+			if loads[s] < loads[least] {
+				least = s
+			}
+		}
+		table[i] = least
+		loads[least] += counts[i]
+	}
+	return table
+}
+
+// autoReshardFactor is how far the mean shard count of a Resizable map
+// may exceed its WithAutoReshard target before the map grows.
+const autoReshardFactor = 2
+
+// Resizable is a DMap that grows its shard count as it fills up, as
+// configured WithAutoReshard. A DMap's shard count is fixed, so
+// resharding builds a new map and swaps it in; Resizable is the handle
+// that always refers to the current one.
+//
+// Growing copies every entry (see Reshard) and all operations on the
+// Resizable wait for the copy to finish, a pause proportional to Count.
+// The grown map keeps all options of the original, hooks and background
+// goroutines included, and its subscriptions.
+// Since the shard count at least doubles each time, the copying costs
+// O(1) amortized per insert.
+type Resizable[K comparable, V any] struct {
+	// mu is held for writing only while swapping maps.
+	mu      sync.RWMutex
+	m       DMap[K, V]
+	target  int
+	sets    int64
+	growing int32
+}
+
+// NewResizable creates a Resizable map with nShards initial shards,
+// configured by the given options. Without WithAutoReshard it never
+// grows. It panics like New on invalid arguments.
+func NewResizable[K comparable, V any](nShards int, opts ...Option[K, V]) *Resizable[K, V] {
+	m := New(nShards, opts...)
+	return &Resizable[K, V]{m: m, target: m.config().autoReshard}
+}
+
+// Map returns the current map. It is a snapshot: after the next reshard
+// it no longer receives writes made through the Resizable, and writes
+// made directly to it may be lost.
+func (r *Resizable[K, V]) Map() DMap[K, V] {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.m
+}
+
+// Shards returns the current number of shards.
+func (r *Resizable[K, V]) Shards() int {
+	return len(r.Map())
+}
+
+// Get is DMap.Get on the current map.
+func (r *Resizable[K, V]) Get(key K) (V, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.m.Get(key)
+}
+
+// Has is DMap.Has on the current map.
+func (r *Resizable[K, V]) Has(key K) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.m.Has(key)
+}
+
+// Count is DMap.Count on the current map.
+func (r *Resizable[K, V]) Count() int64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.m.Count()
+}
+
+// Keys is DMap.Keys on the current map.
+func (r *Resizable[K, V]) Keys() []K {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.m.Keys()
+}
+
+// Remove is DMap.Remove on the current map.
+func (r *Resizable[K, V]) Remove(key K) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	r.m.Remove(key)
+}
+
+// Close is DMap.Close on the current map.
+func (r *Resizable[K, V]) Close() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.m.Close()
+}
+
+// Set is DMap.Set on the current map. If the map has outgrown its
+// target, Set starts growing it in the background.
+func (r *Resizable[K, V]) Set(key K, val V) {
+	r.mu.RLock()
+	m, target := r.m, r.target
+	m.Set(key, val)
+	r.mu.RUnlock()
+	if target <= 0 {
+		return
+	}
+	// Count is O(shards), so only check once every len(m) writes.
+	if atomic.AddInt64(&r.sets, 1)%int64(len(m)) == 0 &&
+		m.Count() > int64(autoReshardFactor*target*len(m)) &&
+		atomic.CompareAndSwapInt32(&r.growing, 0, 1) {
+		go r.grow()
+	}
+}
+
+// grow swaps in a copy of the map with enough shards to bring the mean
+// shard count back to the target.
+func (r *Resizable[K, V]) grow() {
+	defer atomic.StoreInt32(&r.growing, 0)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count := r.m.Count()
+	n := len(r.m)
+	for count > int64(r.target*n) {
+		n *= 2
+	}
+	grown, err := r.m.Reshard(n)
+	if err != nil {
+		// e.g. weights for a fixed shard count; stay as is.
+		r.target = 0
+		return
+	}
+	r.m.handOver(grown)
+	r.m = grown
+}
+
+// handOver makes next, a copy of m built by Reshard or Rebalance, take
+// m's place. It stops m's background goroutines and delivers the events
+// m buffered, then gives next m's full configuration (the copy is built
+// with the derived one, so it fires no hooks and writes nothing behind)
+// but for next's own lock assignment, the keys m had yet to write behind
+// and m's subscriptions, and starts next's background goroutines. m then takes no further part, beyond
+// still publishing to the subscriptions if written to directly.
+// No writes to m or next may be in progress.
+func (m DMap[K, V]) handOver(next DMap[K, V]) {
+	st := m.state()
+	if st.stop != nil {
+		close(st.stop)
+		st.background.Wait()
+		st.stop = nil
+	}
+	m.flushEvents()
+
+	full := *m.config()
+	full.stripeTable = next.config().stripeTable
+	cfg := &full
+	for _, shard := range m {
+		shard.mu.Lock()
+		for key := range shard.dirty {
+			dst := next.getShard(key)
+			if dst.contains(key) {
+				dst.markDirty(key)
+			}
+		}
+		shard.dirty = nil
+		shard.mu.Unlock()
+	}
+	for _, shard := range next {
+		shard.cfg = cfg
+	}
+	next.state().subs = st.subs
+	next.startBackground()
+}
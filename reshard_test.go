@@ -0,0 +1,236 @@
+package dmap
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReshard(t *testing.T) {
+	m := New[string, int](4)
+	for i := 0; i < 1000; i++ {
+		m.Set(fmt.Sprintf("key%d", i), i)
+	}
+	m.SetWithTTL("ttl", -1, time.Hour)
+
+	r, err := m.Reshard(16)
+	require.NoError(t, err)
+	require.Len(t, r, 16)
+	require.Equal(t, m.Items(), r.Items())
+	require.EqualValues(t, m.Count(), r.Count())
+	for _, k := range m.Keys() {
+		require.True(t, r[r.getShardIndex(k)].contains(k))
+	}
+	require.True(t, r.SetTTL("ttl", time.Hour)) // expiry was carried over
+	require.NotEmpty(t, r.getShard("ttl").expires)
+
+	_, err = m.Reshard(0)
+	require.ErrorIs(t, err, ErrInvalidShardCount)
+	w := New[string, int](2, WithShardWeights[string, int]([]int{1, 2}))
+	_, err = w.Reshard(3)
+	require.ErrorIs(t, err, ErrInvalidShardWeights)
+}
+
+func TestAutoReshard(t *testing.T) {
+	r := NewResizable[string, int](2, WithAutoReshard[string, int](10))
+	require.Equal(t, 2, r.Shards())
+	for i := 0; i < 1000; i++ {
+		r.Set(fmt.Sprintf("key%d", i), i)
+	}
+	require.Eventually(t, func() bool {
+		return r.Count() == 1000 && r.Shards() >= 1000/(2*10)
+	}, time.Second, time.Millisecond)
+
+	// Growing may still be under way; reads wait for it.
+	for i := 0; i < 1000; i++ {
+		v, ok := r.Get(fmt.Sprintf("key%d", i))
+		require.True(t, ok)
+		require.Equal(t, i, v)
+	}
+	require.Len(t, r.Keys(), 1000)
+	r.Remove("key0")
+	require.False(t, r.Has("key0"))
+}
+
+func TestAutoReshardKeepsOptions(t *testing.T) {
+	var inserts int64
+	var flushedMu sync.Mutex
+	flushed := map[string]int{}
+	r := NewResizable[string, int](2,
+		WithAutoReshard[string, int](10),
+		WithOnInsert(func(string, int) { atomic.AddInt64(&inserts, 1) }),
+		WithWriteBehind(time.Millisecond, func(entries []Entry[string, int]) error {
+			flushedMu.Lock()
+			defer flushedMu.Unlock()
+			for _, e := range entries {
+				flushed[e.Key] = e.Value
+			}
+			return nil
+		}))
+	events, cancel := r.Map().Subscribe(1000)
+	defer cancel()
+
+	for i := 0; i < 200; i++ {
+		r.Set(fmt.Sprintf("key%d", i), i)
+	}
+	require.Eventually(t, func() bool { return r.Shards() > 2 }, time.Second, time.Millisecond)
+	r.Set("last", 1)
+
+	require.EqualValues(t, 201, atomic.LoadInt64(&inserts))
+	require.Eventually(t, func() bool {
+		flushedMu.Lock()
+		defer flushedMu.Unlock()
+		return len(flushed) == 201
+	}, time.Second, time.Millisecond)
+	got := 0
+	for e := range events {
+		got++
+		if e.Key == "last" {
+			break
+		}
+	}
+	require.Equal(t, 201, got, "subscriptions follow the grown map")
+
+	require.NoError(t, r.Close())
+	_, open := <-events
+	require.False(t, open)
+}
+
+func TestRebalanceKeepsOptions(t *testing.T) {
+	var inserts int64
+	m := New[int, int](8,
+		WithLockStripes[int, int](2),
+		WithOnInsert(func(int, int) { atomic.AddInt64(&inserts, 1) }),
+		WithJanitor[int, int](time.Millisecond))
+	for i := 0; i < 100; i++ {
+		m.Set(i, i)
+	}
+	events, cancel := m.Subscribe(10)
+	defer cancel()
+
+	r := m.Rebalance()
+	require.Nil(t, m.state().stop, "the original's janitor is stopped")
+	require.NotNil(t, r.state().stop)
+	require.EqualValues(t, 100, atomic.LoadInt64(&inserts), "copying fires no hooks")
+	r.Set(100, 100)
+	require.EqualValues(t, 101, atomic.LoadInt64(&inserts))
+	e := <-events
+	require.Equal(t, 100, e.Key, "subscriptions follow the result")
+	require.Equal(t, r.config().stripeTable, r.Clone().config().stripeTable)
+	require.NoError(t, r.Close())
+}
+
+func TestResizableWithoutAutoReshard(t *testing.T) {
+	r := NewResizable[int, int](2)
+	for i := 0; i < 1000; i++ {
+		r.Set(i, i)
+	}
+	require.Equal(t, 2, r.Shards())
+	require.Len(t, r.Map(), 2)
+}
+
+func TestReshardWithProgress(t *testing.T) {
+	m := New[int, int](4)
+	const n = 10*reshardChunk + 17
+	for i := 0; i < n; i++ {
+		m.Set(i, i)
+	}
+
+	var dones []int
+	r, err := m.ReshardWithProgress(context.Background(), 32, func(done, total int) {
+		require.Equal(t, n, total)
+		dones = append(dones, done)
+	})
+	require.NoError(t, err)
+	require.Len(t, dones, 11)
+	for i := 1; i < len(dones); i++ {
+		require.Greater(t, dones[i], dones[i-1])
+	}
+	require.Equal(t, n, dones[len(dones)-1])
+	require.Len(t, r, 32)
+	require.Equal(t, m.Items(), r.Items())
+}
+
+func TestReshardWithProgressCancel(t *testing.T) {
+	m := New[int, int](4)
+	for i := 0; i < 5*reshardChunk; i++ {
+		m.Set(i, i)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	r, err := m.ReshardWithProgress(ctx, 8, func(done, total int) {
+		calls++
+		if calls == 2 {
+			cancel()
+		}
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Nil(t, r)
+	require.Equal(t, 2, calls)
+}
+
+func TestRebalance(t *testing.T) {
+	// Shard i gets key%16 == i, so the 4 large shards 0, 4, 8 and 12
+	// start out sharing stripe 0.
+	m := New[int, int](16,
+		WithLockStripes[int, int](4),
+		WithHasher[int, int](func(k int) uint64 { return uint64(k % 16) }))
+	for i := 0; i < 16; i++ {
+		n := 10
+		if i%4 == 0 {
+			n = 1000
+		}
+		for j := 0; j < n; j++ {
+			m.Set(j*16+i, j)
+		}
+	}
+	m.SetWithTTL(-1, 0, time.Hour)
+	perLock := func(m DMap[int, int]) map[*sync.RWMutex]int64 {
+		counts := map[*sync.RWMutex]int64{}
+		for _, shard := range m {
+			counts[shard.mu] += int64(len(shard.items))
+		}
+		return counts
+	}
+	spread := func(counts map[*sync.RWMutex]int64) int64 {
+		lo, hi := int64(math.MaxInt64), int64(0)
+		for _, n := range counts {
+			if n < lo {
+				lo = n
+			}
+			if n > hi {
+				hi = n
+			}
+		}
+		return hi - lo
+	}
+	require.Len(t, perLock(m), 4)
+	require.EqualValues(t, 3960, spread(perLock(m)))
+
+	r := m.Rebalance()
+	require.Len(t, r, 16)
+	require.Len(t, perLock(r), 4)
+	require.LessOrEqual(t, spread(perLock(r)), int64(10))
+	require.True(t, Equal(m, r))
+	for i := range m {
+		require.Equal(t, len(m[i].items), len(r[i].items), "placement is unchanged")
+	}
+	_, expires := r.getShard(-1).expires[-1]
+	require.True(t, expires)
+
+	// Derived maps keep the assignment.
+	c := r.Clone()
+	for i := range r {
+		require.Equal(t, r[i].stripe, c[i].stripe)
+	}
+
+	// Without striping, there is nothing to rebalance.
+	plain := New[int, int](4)
+	require.Equal(t, plain[0], plain.Rebalance()[0])
+}
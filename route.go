@@ -0,0 +1,235 @@
+package dmap
+
+import "sync/atomic"
+
+// The routed accessors place an entry on the shard of a separate routing
+// key instead of the shard of its own key, so that related entries (e.g.
+// all of one user's) share a shard and can be locked together cheaply.
+//
+// A routed entry can only be found through the routed accessors with the
+// same routeKey: Get, Has, Remove and the other single-key methods look
+// at the shard of the entry's own key. Whole-map operations such as Keys,
+// ForEach and Count do include routed entries.
+//
+// A key is stored on one shard at a time: SetWithRoute moves it to the
+// shard of routeKey, and a plain write such as Set moves it back to the
+// shard of its own key. The routing key is recorded with the entry, so
+// Reshard, Rebalance, CopyInto and derived maps keep routed entries
+// together.
+
+// placement is a key and the shard an entry is stored on: the routing
+// key and shard of a routed entry, or a key and the shard of a copy
+// that a write moving the key left behind.
+type placement[K comparable, V any] struct {
+	key   K
+	shard *Shard[K, V]
+}
+
+// SetWithRoute sets key, val in the map on the shard of routeKey.
+func (m DMap[K, V]) SetWithRoute(routeKey, key K, val V) {
+	routeKey, key = m.normalize(routeKey), m.normalize(key)
+	shard := m.getShard(routeKey)
+	if shard.validate(key, val) != nil {
+		return
+	}
+	home := m.getShard(key)
+	atomic.StoreInt32(&shard.state.routing, 1)
+	var added bool
+	shard.locked(func() {
+		moved := !shard.routedBy(key, routeKey)
+		var stored bool
+		if stored, added = shard.set(key, val); stored {
+			shard.route(key, routeKey, home, moved)
+		}
+	})
+	if added {
+		m.evictOverflow(key)
+	} else {
+		m.dropDisplaced()
+	}
+}
+
+// GetWithRoute returns the value of key stored with SetWithRoute(routeKey, ...).
+func (m DMap[K, V]) GetWithRoute(routeKey, key K) (V, bool) {
+	routeKey, key = m.normalize(routeKey), m.normalize(key)
+	shard := m.getShard(routeKey)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	v, ok := shard.lookup(key)
+	if ok {
+		v = shard.readCopy(v)
+	}
+	return v, ok
+}
+
+// RemoveWithRoute deletes key stored with SetWithRoute(routeKey, ...).
+func (m DMap[K, V]) RemoveWithRoute(routeKey, key K) {
+	routeKey, key = m.normalize(routeKey), m.normalize(key)
+	shard := m.getShard(routeKey)
+	if !shard.lockWrite() {
+		return
+	}
+	defer shard.mu.Unlock()
+	shard.delete(key)
+}
+
+// routedBy reports whether key is stored on the shard, routed by
+// routeKey. The caller must hold the shard's lock.
+func (s *Shard[K, V]) routedBy(key, routeKey K) bool {
+	st := s.state
+	st.routeMu.Lock()
+	defer st.routeMu.Unlock()
+	r, ok := st.routes[key]
+	return ok && r.key == routeKey && r.shard == s
+}
+
+// route records key, just stored on the shard, as routed by routeKey.
+// If moved is set, key may also be stored on home, the shard of its own
+// key, and that copy is queued for dropDisplaced.
+// The caller must hold the shard's write lock.
+func (s *Shard[K, V]) route(key, routeKey K, home *Shard[K, V], moved bool) {
+	st := s.state
+	st.routeMu.Lock()
+	defer st.routeMu.Unlock()
+	if st.routes == nil {
+		st.routes = make(map[K]placement[K, V])
+	}
+	st.routes[key] = placement[K, V]{routeKey, s}
+	if moved && home != s {
+		st.displaced = append(st.displaced, placement[K, V]{key, home})
+	}
+}
+
+// settle is called by set for key, just stored on the shard. If key was
+// routed to another shard, it now lives here: settle forgets the route,
+// queues the copy there for dropDisplaced and reports true. SetWithRoute
+// then records the new route, if any. The caller must hold the shard's
+// write lock.
+func (s *Shard[K, V]) settle(key K) bool {
+	st := s.state
+	st.routeMu.Lock()
+	defer st.routeMu.Unlock()
+	r, ok := st.routes[key]
+	if !ok || r.shard == s {
+		return false
+	}
+	delete(st.routes, key)
+	st.displaced = append(st.displaced, placement[K, V]{key, r.shard})
+	return true
+}
+
+// unroute forgets the route of key, just removed from the shard, if it
+// was routed there. The caller must hold the shard's write lock.
+func (s *Shard[K, V]) unroute(key K) {
+	st := s.state
+	st.routeMu.Lock()
+	defer st.routeMu.Unlock()
+	if r, ok := st.routes[key]; ok && r.shard == s {
+		delete(st.routes, key)
+	}
+}
+
+// unrouteAll forgets the routes of the keys routed to the shard.
+// The caller must hold the shard's write lock.
+func (s *Shard[K, V]) unrouteAll() {
+	st := s.state
+	st.routeMu.Lock()
+	defer st.routeMu.Unlock()
+	for key, r := range st.routes {
+		if r.shard == s {
+			delete(st.routes, key)
+		}
+	}
+}
+
+// dropDisplaced removes the copies queued by route and settle, unless
+// the key has since been stored back on the copy's shard.
+func (m DMap[K, V]) dropDisplaced() {
+	st := m.state()
+	if atomic.LoadInt32(&st.routing) == 0 {
+		return
+	}
+	st.routeMu.Lock()
+	displaced := st.displaced
+	st.displaced = nil
+	st.routeMu.Unlock()
+	for _, d := range displaced {
+		d.shard.dropCopy(d.key, m.getShard(d.key))
+	}
+}
+
+// dropCopy removes the shard's copy of key unless the shard is where
+// key belongs (see belongs).
+func (s *Shard[K, V]) dropCopy(key K, home *Shard[K, V]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state.writeErr() != nil {
+		return
+	}
+	if !s.belongs(key, home) {
+		s.removePrimary(key)
+	}
+}
+
+// belongs reports whether the shard is where key belongs: the shard it
+// is routed to, or else home, the shard of its own key.
+func (s *Shard[K, V]) belongs(key K, home *Shard[K, V]) bool {
+	st := s.state
+	st.routeMu.Lock()
+	defer st.routeMu.Unlock()
+	if r, ok := st.routes[key]; ok {
+		return r.shard == s
+	}
+	return home == s
+}
+
+// routeTable returns a copy of the routes of m, or nil if SetWithRoute
+// was never used on it.
+func (m DMap[K, V]) routeTable() map[K]placement[K, V] {
+	st := m.state()
+	if atomic.LoadInt32(&st.routing) == 0 {
+		return nil
+	}
+	st.routeMu.Lock()
+	defer st.routeMu.Unlock()
+	routes := make(map[K]placement[K, V], len(st.routes))
+	for key, r := range st.routes {
+		routes[key] = r
+	}
+	return routes
+}
+
+// placedOn reports whether shard, one of m's, is where key belongs by
+// routes, a copy of m's (see belongs), rather than holding a copy of key
+// not yet dropped.
+func (m DMap[K, V]) placedOn(routes map[K]placement[K, V], key K, shard *Shard[K, V]) bool {
+	if routes == nil {
+		return true
+	}
+	if r, ok := routes[key]; ok {
+		return r.shard == shard
+	}
+	return m.getShard(key) == shard
+}
+
+// carryRoutes records routes, a copy of the routes of src, in dst, a map
+// built from src with the same shard count, for the routed keys dst
+// holds on the same shard index. dst must not be in use yet.
+func carryRoutes[K comparable, V, W any](src DMap[K, V], routes map[K]placement[K, V], dst DMap[K, W]) {
+	if routes == nil {
+		return
+	}
+	index := make(map[*Shard[K, V]]int, len(src))
+	for i, shard := range src {
+		index[shard] = i
+	}
+	st := dst.state()
+	st.routing = 1
+	st.routes = make(map[K]placement[K, W], len(routes))
+	for key, r := range routes {
+		shard := dst[index[r.shard]]
+		if _, ok := shard.items[key]; ok {
+			st.routes[key] = placement[K, W]{r.key, shard}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package dmap
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutedAccessors(t *testing.T) {
+	m := New[string, string](16)
+
+	// Find two keys that would normally land on different shards.
+	k1, k2 := "user:1:name", ""
+	for i := 0; ; i++ {
+		k2 = fmt.Sprintf("user:1:email:%d", i)
+		if m.getShardIndex(k2) != m.getShardIndex(k1) {
+			break
+		}
+	}
+
+	m.SetWithRoute("user:1", k1, "alice")
+	m.SetWithRoute("user:1", k2, "alice@example.com")
+
+	route := m.getShardIndex("user:1")
+	require.Contains(t, m[route].items, k1)
+	require.Contains(t, m[route].items, k2)
+	require.EqualValues(t, 2, m.Count())
+	require.ElementsMatch(t, []string{k1, k2}, m.Keys())
+
+	v, ok := m.GetWithRoute("user:1", k1)
+	require.True(t, ok)
+	require.Equal(t, "alice", v)
+	v, ok = m.GetWithRoute("user:1", k2)
+	require.True(t, ok)
+	require.Equal(t, "alice@example.com", v)
+
+	m.RemoveWithRoute("user:1", k1)
+	_, ok = m.GetWithRoute("user:1", k1)
+	require.False(t, ok)
+	require.EqualValues(t, 1, m.Count())
+}
+
+// routedPair returns a key and a routing key that land on different
+// shards of m, and a routing key other than route on yet another one.
+func routedPair(m DMap[string, int]) (key, route, other string) {
+	key = "key"
+	for i := 0; route == "" || other == ""; i++ {
+		k := fmt.Sprintf("route:%d", i)
+		switch {
+		case m.getShardIndex(k) == m.getShardIndex(key):
+		case route == "":
+			route = k
+		case m.getShardIndex(k) != m.getShardIndex(route):
+			other = k
+		}
+	}
+	return key, route, other
+}
+
+func TestRoutedKeyLivesOnOneShard(t *testing.T) {
+	m := New[string, int](16)
+	key, route, other := routedPair(m)
+
+	m.Set(key, 1)
+	m.SetWithRoute(route, key, 2)
+	require.EqualValues(t, 1, m.Count())
+	require.Equal(t, []string{key}, m.Keys())
+	require.False(t, m.Has(key))
+	v, ok := m.GetWithRoute(route, key)
+	require.True(t, ok)
+	require.Equal(t, 2, v)
+
+	m.SetWithRoute(other, key, 3)
+	require.EqualValues(t, 1, m.Count())
+	_, ok = m.GetWithRoute(route, key)
+	require.False(t, ok)
+
+	m.Set(key, 4)
+	require.EqualValues(t, 1, m.Count())
+	_, ok = m.GetWithRoute(other, key)
+	require.False(t, ok)
+	v, _ = m.Get(key)
+	require.Equal(t, 4, v)
+	require.Empty(t, m.state().routes)
+
+	m.SetWithRoute(route, key, 5)
+	m.RemoveWithRoute(route, key)
+	require.Zero(t, m.Count())
+	require.Empty(t, m.state().routes)
+}
+
+func TestRoutesSurviveRehashing(t *testing.T) {
+	m := New[string, int](16, WithLockStripes[string, int](4))
+	key, route, _ := routedPair(m)
+	m.SetWithRoute(route, key, 1)
+	m.Set("plain", 2)
+
+	requireRouted := func(t *testing.T, m DMap[string, int]) {
+		t.Helper()
+		v, ok := m.GetWithRoute(route, key)
+		require.True(t, ok)
+		require.Equal(t, 1, v)
+		require.True(t, m.Has("plain"))
+		require.EqualValues(t, 2, m.Count())
+	}
+	resharded, err := m.Reshard(8)
+	require.NoError(t, err)
+	requireRouted(t, resharded)
+	again, err := resharded.Reshard(32)
+	require.NoError(t, err)
+	requireRouted(t, again)
+
+	dst := New[string, int](5)
+	m.CopyInto(dst)
+	requireRouted(t, dst)
+
+	requireRouted(t, m.Clone())
+	c, err := m.Clone().Reshard(3)
+	require.NoError(t, err)
+	requireRouted(t, c)
+
+	r := m.Rebalance()
+	requireRouted(t, r)
+	c, err = r.Reshard(7)
+	require.NoError(t, err)
+	requireRouted(t, c)
+}
+
+func TestRoutedKeyConcurrentWrites(t *testing.T) {
+	m := New[string, int](16)
+	key, route, other := routedPair(m)
+	var wg sync.WaitGroup
+	for _, write := range []func(int){
+		func(i int) { m.Set(key, i) },
+		func(i int) { m.SetWithRoute(route, key, i) },
+		func(i int) { m.SetWithRoute(other, key, i) },
+	} {
+		wg.Add(1)
+		go func(write func(int)) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				write(i)
+				runtime.Gosched()
+			}
+		}(write)
+	}
+	wg.Wait()
+	require.EqualValues(t, 1, m.Count())
+	require.Len(t, m.Keys(), 1)
+}
@@ -0,0 +1,19 @@
+package dmap
+
+import "database/sql"
+
+// ScanRows sets the key, value pair extract returns for each of rows in
+// m, e.g. to load a table into a map. It stops at the first extract
+// error and returns it, and otherwise returns rows.Err(). Pairs set
+// before an error stay in the map. The caller still owns rows and must
+// close it.
+func ScanRows[K comparable, V any](m DMap[K, V], rows *sql.Rows, extract func(*sql.Rows) (K, V, error)) error {
+	for rows.Next() {
+		k, v, err := extract(rows)
+		if err != nil {
+			return err
+		}
+		m.Set(k, v)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,93 @@
+package dmap
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDriver serves every query with the rows of fakeTable, as
+// (name string, age int64) pairs.
+type fakeDriver struct{}
+
+var fakeTable = [][]driver.Value{
+	{"alice", int64(31)},
+	{"bob", int64(42)},
+	{"carol", int64(27)},
+}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { return fakeStmt{}, nil }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+type fakeStmt struct{}
+
+func (fakeStmt) Close() error                               { return nil }
+func (fakeStmt) NumInput() int                              { return 0 }
+func (fakeStmt) Exec([]driver.Value) (driver.Result, error) { return nil, errors.New("not supported") }
+func (fakeStmt) Query([]driver.Value) (driver.Rows, error)  { return &fakeRows{}, nil }
+
+type fakeRows struct{ next int }
+
+func (*fakeRows) Columns() []string { return []string{"name", "age"} }
+func (*fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(fakeTable) {
+		return io.EOF
+	}
+	copy(dest, fakeTable[r.next])
+	r.next++
+	return nil
+}
+
+func init() {
+	sql.Register("dmapfake", fakeDriver{})
+}
+
+func queryFakeTable(t *testing.T) *sql.Rows {
+	db, err := sql.Open("dmapfake", "")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	rows, err := db.Query("SELECT name, age FROM people")
+	require.NoError(t, err)
+	t.Cleanup(func() { rows.Close() })
+	return rows
+}
+
+func TestScanRows(t *testing.T) {
+	m := New[string, int](4)
+	err := ScanRows(m, queryFakeTable(t), func(rows *sql.Rows) (string, int, error) {
+		var name string
+		var age int
+		err := rows.Scan(&name, &age)
+		return name, age, err
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"alice": 31, "bob": 42, "carol": 27}, m.Items())
+}
+
+func TestScanRowsExtractError(t *testing.T) {
+	m := New[string, int](4)
+	bad := errors.New("bad row")
+	err := ScanRows(m, queryFakeTable(t), func(rows *sql.Rows) (string, int, error) {
+		var name string
+		var age int
+		if err := rows.Scan(&name, &age); err != nil {
+			return "", 0, err
+		}
+		if name == "bob" {
+			return "", 0, bad
+		}
+		return name, age, nil
+	})
+	require.ErrorIs(t, err, bad)
+	require.Equal(t, map[string]int{"alice": 31}, m.Items())
+}
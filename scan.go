@@ -0,0 +1,224 @@
+package dmap
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Cursor is an opaque position in a paged Scan of a DMap.
+// The zero Cursor starts at the beginning of the map.
+type Cursor struct {
+	shard  int
+	offset int
+}
+
+// Scan returns up to limit entries starting at cursor, the cursor to
+// resume from, and whether the scan has reached the end of the map.
+// Shards are scanned in index order and, within a shard, entries are
+// ordered by the hash of their key, so paging an unchanged map visits
+// every entry exactly once.
+//
+// Scan holds one shard's read lock at a time, and only for the duration
+// of one call. Entries written or removed between calls may be missed or
+// returned twice; results are best-effort under concurrent mutation.
+//
+// Each shard sorts its keys once and keeps the order until a key is
+// added or removed, so paging through an unchanged shard of n keys costs
+// O(n log n) in all, plus O(limit) per page. Under constant inserts or
+// removals, every page may sort its shard again. Scan panics with
+// ErrInvalidLimit if limit < 1.
+func (m DMap[K, V]) Scan(cursor Cursor, limit int) (entries []Entry[K, V], next Cursor, done bool) {
+	if limit < 1 {
+		panic(ErrInvalidLimit)
+	}
+	for cursor.shard < len(m) && len(entries) < limit {
+		var end bool
+		entries, cursor.offset, end = m[cursor.shard].scan(entries, cursor.offset, limit)
+		if end {
+			cursor = Cursor{shard: cursor.shard + 1}
+		}
+	}
+	return entries, cursor, cursor.shard >= len(m)
+}
+
+// KeysStable returns the keys of the map grouped by ascending shard
+// index and, within a shard, ordered by key hash as in Scan, so unchanged
+// contents always give the same order (unlike Keys). Shards are read
+// concurrently (see WithFanOutLimit).
+func (m DMap[K, V]) KeysStable() []K {
+	perShard := make([][]K, len(m))
+	index := make(map[*Shard[K, V]]int, len(m))
+	for i, shard := range m {
+		index[shard] = i
+	}
+	m.fanOut(func(shard *Shard[K, V]) {
+		shard.mu.RLock()
+		defer shard.mu.RUnlock()
+		perShard[index[shard]] = shard.hashOrderedKeys()
+	})
+	keys := make([]K, 0)
+	for _, ks := range perShard {
+		keys = append(keys, ks...)
+	}
+	return keys
+}
+
+// OrderedKeys returns the keys of the map sorted in ascending order, for
+// callers that need a deterministic order (see also KeysStable, which
+// works for any key type but is ordered by hash). The keys are collected
+// from the shards as in Keys, then sorted once.
+func OrderedKeys[K cmp.Ordered, V any](m DMap[K, V]) []K {
+	keys := m.Keys()
+	slices.Sort(keys)
+	return keys
+}
+
+// KeysInterned is like Keys, for string keys only, but copies the keys
+// into a single arena: every returned string is a slice of one shared
+// buffer. Keys returns strings that share storage with the map's own
+// keys, so it allocates only the slice; KeysInterned makes one more
+// allocation for all the key bytes, but the result no longer pins the
+// map's keys (or whatever buffers they were cut from) and is a single
+// object for the garbage collector to scan, instead of one per key.
+// Retaining any one returned key retains the whole arena.
+func KeysInterned[V any](m DMap[string, V]) []string {
+	keys := m.Keys()
+	size := 0
+	for _, k := range keys {
+		size += len(k)
+	}
+	var b strings.Builder
+	b.Grow(size)
+	for _, k := range keys {
+		b.WriteString(k)
+	}
+	arena := b.String()
+	off := 0
+	for i, k := range keys {
+		keys[i] = arena[off : off+len(k)]
+		off += len(k)
+	}
+	return keys
+}
+
+// FilterChan streams the entries for which pred returns true over the
+// returned channel, like Filter without building a result map, e.g. for
+// memory-bounded scans of huge maps. A producer goroutine collects the
+// matching entries of one shard at a time, running pred under that
+// shard's read lock (so pred must not modify m), and sends them with no
+// lock held, so the consumer may use the map. The channel is closed once
+// all shards are done, ctx is done, or pred panics WithRecover (without
+// it, a panic in pred crashes the program, as it runs on its own
+// goroutine); a consumer that stops early must
+// cancel ctx to release the producer. Values are copied WithCopyOnRead.
+func (m DMap[K, V]) FilterChan(ctx context.Context, pred func(K, V) bool) <-chan Entry[K, V] {
+	out := make(chan Entry[K, V])
+	go func() {
+		defer close(out)
+		for _, shard := range m {
+			entries, ok := shard.filterGuarded(pred)
+			for _, e := range entries {
+				select {
+				case out <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if !ok {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// filter returns the live entries of the shard for which pred returns true.
+func (s *Shard[K, V]) filter(pred func(K, V) bool) []Entry[K, V] {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := s.now()
+	var entries []Entry[K, V]
+	for k, v := range s.items {
+		if !s.expiredAt(k, now) && pred(k, v) {
+			entries = append(entries, Entry[K, V]{Key: k, Value: s.readCopy(v)})
+		}
+	}
+	return entries
+}
+
+// filterGuarded is like filter, but runs pred WithRecover. If pred
+// panics, it returns the entries matched so far and false.
+func (s *Shard[K, V]) filterGuarded(pred func(K, V) bool) ([]Entry[K, V], bool) {
+	ok := true
+	entries := s.filter(func(k K, v V) bool {
+		match := false
+		if ok && !s.cfg.guard(func() { match = pred(k, v) }) {
+			ok = false
+		}
+		return match
+	})
+	return entries, ok
+}
+
+// scan appends the live entries of the shard to entries, in key hash
+// order starting at position offset of the shard's scan order, until
+// entries holds limit. It returns entries, the position to resume from
+// and whether the shard is done.
+func (s *Shard[K, V]) scan(entries []Entry[K, V], offset, limit int) ([]Entry[K, V], int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := s.scanOrder()
+	now := s.now()
+	for ; offset < len(keys) && len(entries) < limit; offset++ {
+		k := keys[offset]
+		if !s.expiredAt(k, now) {
+			entries = append(entries, Entry[K, V]{Key: k, Value: s.readCopy(s.items[k])})
+		}
+	}
+	return entries, offset, offset >= len(keys)
+}
+
+// scanOrder returns all keys of the shard, expired ones included, ordered
+// by hash, sorting them only if a key was added or removed since the last
+// call. Writers that add or remove keys reset the order under the write
+// lock; scanMu lets concurrent readers, which only hold the read lock,
+// fill it in. The caller must hold the shard's lock.
+func (s *Shard[K, V]) scanOrder() []K {
+	s.scanMu.Lock()
+	defer s.scanMu.Unlock()
+	if s.scanKeys == nil {
+		s.scanKeys = sortByHash(s.items, func(K) bool { return true })
+	}
+	return s.scanKeys
+}
+
+// hashOrderedKeys returns the live keys of the shard ordered by hash.
+// The caller must hold the shard's lock.
+func (s *Shard[K, V]) hashOrderedKeys() []K {
+	now := s.now()
+	return sortByHash(s.items, func(key K) bool { return !s.expiredAt(key, now) })
+}
+
+// sortByHash returns the keys of items for which keep returns true,
+// ordered by hash.
+func sortByHash[K comparable, V any](items map[K]V, keep func(K) bool) []K {
+	type hashed struct {
+		key  K
+		hash uint64
+	}
+	hs := make([]hashed, 0, len(items))
+	for key := range items {
+		if keep(key) {
+			hs = append(hs, hashed{key, hashKey(key)})
+		}
+	}
+	sort.Slice(hs, func(i, j int) bool { return hs[i].hash < hs[j].hash })
+	keys := make([]K, len(hs))
+	for i, h := range hs {
+		keys[i] = h.key
+	}
+	return keys
+}
@@ -0,0 +1,223 @@
+package dmap
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScan(t *testing.T) {
+	m := New[string, int](10)
+	for i := 0; i < 1000; i++ {
+		m.Set(fmt.Sprintf("key_%d", i), i)
+	}
+
+	for _, limit := range []int{1, 7, 100, 5000} {
+		seen := map[string]int{}
+		var cursor Cursor
+		pages := 0
+		for {
+			entries, next, done := m.Scan(cursor, limit)
+			require.LessOrEqual(t, len(entries), limit)
+			for _, e := range entries {
+				_, dup := seen[e.Key]
+				require.False(t, dup, "key %s returned twice", e.Key)
+				seen[e.Key] = e.Value
+			}
+			pages++
+			require.Less(t, pages, 2000, "scan does not terminate")
+			if done {
+				break
+			}
+			cursor = next
+		}
+		require.Equal(t, m.Items(), seen, "limit %d", limit)
+	}
+}
+
+func TestScanReusesOrder(t *testing.T) {
+	clock := newFakeClock()
+	m := New[int, int](1, WithClock[int, int](clock))
+	for i := 0; i < 100; i++ {
+		m.Set(i, i)
+	}
+	m.SetWithTTL(100, 100, time.Second)
+	clock.Advance(time.Minute)
+
+	entries, cursor, _ := m.Scan(Cursor{}, 10)
+	require.Len(t, entries, 10)
+	order := m[0].scanKeys
+	require.Len(t, order, 101, "expired keys stay in the order")
+	seen := len(entries)
+	for done := false; !done; {
+		entries, cursor, done = m.Scan(cursor, 10)
+		seen += len(entries)
+		require.Equal(t, &order[0], &m[0].scanKeys[0], "sorted once")
+	}
+	require.Equal(t, 100, seen, "expired entries are skipped")
+
+	m.Set(7, 70) // no new key
+	require.NotNil(t, m[0].scanKeys)
+	m.Set(200, 200)
+	require.Nil(t, m[0].scanKeys)
+	m.Scan(Cursor{}, 1)
+	m.Remove(200)
+	require.Nil(t, m[0].scanKeys)
+
+	require.PanicsWithValue(t, ErrInvalidLimit, func() { m.Scan(Cursor{}, 0) })
+	require.PanicsWithValue(t, ErrInvalidLimit, func() { m.Scan(Cursor{}, -1) })
+}
+
+func TestScanEmpty(t *testing.T) {
+	m := New[string, int](4)
+	entries, _, done := m.Scan(Cursor{}, 10)
+	require.Empty(t, entries)
+	require.True(t, done)
+}
+
+func TestKeysStable(t *testing.T) {
+	m := New[string, int](8)
+	for i := 0; i < 1000; i++ {
+		m.Set(fmt.Sprintf("key%d", i), i)
+	}
+
+	first := m.KeysStable()
+	require.ElementsMatch(t, m.Keys(), first)
+	for i := 0; i < 5; i++ {
+		require.Equal(t, first, m.KeysStable())
+	}
+	for i := 1; i < len(first); i++ {
+		prev, cur := m.getShardIndex(first[i-1]), m.getShardIndex(first[i])
+		require.LessOrEqual(t, prev, cur, "keys must be grouped by shard")
+		if prev == cur {
+			require.Less(t, hashKey(first[i-1]), hashKey(first[i]))
+		}
+	}
+}
+
+func TestKeysInterned(t *testing.T) {
+	m := New[string, int](10)
+	for i := 0; i < 1000; i++ {
+		m.Set(fmt.Sprintf("tenant/region/service/key_%d", i), i)
+	}
+	keys := KeysInterned(m)
+	require.ElementsMatch(t, m.Keys(), keys)
+	for _, k := range keys {
+		require.True(t, m.Has(k))
+	}
+	require.Empty(t, KeysInterned(New[string, int](4)))
+}
+
+// BenchmarkKeysInterned compares Keys, which shares the map's key storage
+// and allocates only the slice, with KeysInterned, which also copies every
+// key into one arena allocation. The arena is what the result retains
+// once the map's own keys are gone, instead of one object per key.
+func BenchmarkKeysInterned(b *testing.B) {
+	m := New[string, int](10)
+	for i := 0; i < 100000; i++ {
+		m.Set(fmt.Sprintf("tenant/region/service/shard/key_%d", i), i)
+	}
+	b.Run("Keys", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = m.Keys()
+		}
+	})
+	b.Run("KeysInterned", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = KeysInterned(m)
+		}
+	})
+}
+
+func TestFilterChan(t *testing.T) {
+	m := New[string, int](10)
+	want := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		k := fmt.Sprintf("key_%d", i)
+		m.Set(k, i)
+		if i%3 == 0 {
+			want[k] = i
+		}
+	}
+
+	got := map[string]int{}
+	for e := range m.FilterChan(context.Background(), func(_ string, v int) bool { return v%3 == 0 }) {
+		// No lock is held while the consumer runs.
+		m.Set("written", 1)
+		got[e.Key] = e.Value
+	}
+	require.Equal(t, want, got)
+}
+
+func TestFilterChanRecover(t *testing.T) {
+	var recovered []any
+	m := New[string, int](1, WithRecover[string, int](func(r any) { recovered = append(recovered, r) }))
+	m.Set("a", 1)
+	m.Set("b", 2)
+	n := 0
+	for range m.FilterChan(context.Background(), func(string, int) bool {
+		n++
+		if n == 2 {
+			panic("pred")
+		}
+		return true
+	}) {
+	}
+	require.Equal(t, []any{"pred"}, recovered)
+	m.Set("c", 3)
+}
+
+func TestFilterChanCancel(t *testing.T) {
+	m := New[string, int](10)
+	for i := 0; i < 1000; i++ {
+		m.Set(fmt.Sprintf("key_%d", i), i)
+	}
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := m.FilterChan(ctx, func(string, int) bool { return true })
+	<-ch
+	cancel()
+	// The channel is closed after at most one more entry.
+	n := 0
+	for range ch {
+		n++
+	}
+	require.LessOrEqual(t, n, 1)
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	require.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+func TestOrderedKeys(t *testing.T) {
+	ints := New[int, string](10)
+	perm := rand.New(rand.NewSource(1)).Perm(1000)
+	for _, i := range perm {
+		ints.Set(i-500, "")
+	}
+	keys := OrderedKeys(ints)
+	require.Len(t, keys, 1000)
+	for i, k := range keys {
+		require.Equal(t, i-500, k)
+	}
+
+	strs := New[string, int](10)
+	for i := 0; i < 1000; i++ {
+		strs.Set(fmt.Sprintf("key_%04d", perm[i]), i)
+	}
+	skeys := OrderedKeys(strs)
+	require.Len(t, skeys, 1000)
+	require.True(t, sort.StringsAreSorted(skeys))
+	require.Equal(t, "key_0000", skeys[0])
+	require.Equal(t, "key_0999", skeys[999])
+
+	require.Empty(t, OrderedKeys(New[int, int](4)))
+}
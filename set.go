@@ -0,0 +1,81 @@
+package dmap
+
+// Set is a thread-safe, sharded set of keys, built on a DMap with
+// empty struct values.
+type Set[K comparable] struct {
+	m DMap[K, struct{}]
+}
+
+// NewSet creates a new Set with nShards number of shards.
+func NewSet[K comparable](nShards int, opts ...Option[K, struct{}]) Set[K] {
+	return Set[K]{m: New(nShards, opts...)}
+}
+
+// Add adds the given keys to the set.
+func (s Set[K]) Add(keys ...K) {
+	for _, key := range keys {
+		s.m.Set(key, struct{}{})
+	}
+}
+
+// Remove removes the given keys from the set (if found).
+func (s Set[K]) Remove(keys ...K) {
+	for _, key := range keys {
+		s.m.Remove(key)
+	}
+}
+
+// Contains reports whether key is in the set.
+func (s Set[K]) Contains(key K) bool {
+	return s.m.Has(key)
+}
+
+// Len returns the number of keys in the set.
+func (s Set[K]) Len() int64 {
+	return s.m.Count()
+}
+
+// Items returns all keys in the set, in no particular order.
+func (s Set[K]) Items() []K {
+	return s.m.Keys()
+}
+
+// Union returns a new set with the keys that are in s or other.
+// The result has the same shard count and options as s.
+func (s Set[K]) Union(other Set[K]) Set[K] {
+	out := Set[K]{m: s.m.Clone()}
+	out.Add(other.Items()...)
+	return out
+}
+
+// Intersect returns a new set with the keys that are in both s and other.
+func (s Set[K]) Intersect(other Set[K]) Set[K] {
+	return s.filter(other.Contains)
+}
+
+// Difference returns a new set with the keys of s that are not in other.
+func (s Set[K]) Difference(other Set[K]) Set[K] {
+	return s.filter(func(key K) bool { return !other.Contains(key) })
+}
+
+// filter returns a new set with the keys of s for which keep is true.
+// Each shard of s is copied out first, so keep can safely read other
+// sets (or s itself) without nesting shard locks.
+func (s Set[K]) filter(keep func(K) bool) Set[K] {
+	out := Set[K]{m: newLike(s.m)}
+	for _, shard := range s.m {
+		shard.mu.RLock()
+		keys := make([]K, 0, len(shard.items))
+		for key := range shard.items {
+			keys = append(keys, key)
+		}
+		shard.mu.RUnlock()
+
+		for _, key := range keys {
+			if keep(key) {
+				out.Add(key)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,72 @@
+package dmap
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetBasics(t *testing.T) {
+	s := NewSet[string](4)
+	s.Add("a", "b", "c", "a")
+
+	require.EqualValues(t, 3, s.Len())
+	require.True(t, s.Contains("a"))
+	require.False(t, s.Contains("d"))
+	require.ElementsMatch(t, []string{"a", "b", "c"}, s.Items())
+
+	s.Remove("a", "d")
+	require.EqualValues(t, 2, s.Len())
+	require.False(t, s.Contains("a"))
+}
+
+func TestSetAlgebra(t *testing.T) {
+	a := NewSet[int](4)
+	a.Add(1, 2, 3, 4)
+	b := NewSet[int](7)
+	b.Add(3, 4, 5)
+
+	require.ElementsMatch(t, []int{1, 2, 3, 4, 5}, a.Union(b).Items())
+	require.ElementsMatch(t, []int{3, 4}, a.Intersect(b).Items())
+	require.ElementsMatch(t, []int{1, 2}, a.Difference(b).Items())
+	require.ElementsMatch(t, []int{5}, b.Difference(a).Items())
+
+	// Operands are left untouched and results keep the receiver's layout.
+	require.EqualValues(t, 4, a.Len())
+	require.EqualValues(t, 3, b.Len())
+	require.Equal(t, 4, len(a.Union(b).m))
+
+	require.ElementsMatch(t, a.Items(), a.Intersect(a).Items())
+	require.Empty(t, a.Difference(a).Items())
+	empty := NewSet[int](4)
+	require.ElementsMatch(t, a.Items(), a.Union(empty).Items())
+	require.Empty(t, a.Intersect(empty).Items())
+}
+
+func TestSetConcurrent(t *testing.T) {
+	s := NewSet[string](8)
+	wg := sync.WaitGroup{}
+	for w := 0; w < 8; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				s.Add(fmt.Sprintf("key_%d_%d", w, i))
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				s.Contains(fmt.Sprintf("key_%d_%d", w, i))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	require.EqualValues(t, 8*500, s.Len())
+	for w := 0; w < 8; w++ {
+		require.True(t, s.Contains(fmt.Sprintf("key_%d_499", w)))
+	}
+}
@@ -0,0 +1,199 @@
+package dmap
+
+import "sync/atomic"
+
+func (s *state[K, V]) recordLookup(hit bool) {
+	if hit {
+		atomic.AddInt64(&s.hits, 1)
+	} else {
+		atomic.AddInt64(&s.misses, 1)
+	}
+}
+
+// MapStats is a point-in-time health snapshot of a DMap.
+type MapStats struct {
+	// Count is the total number of items across all shards.
+	Count int64
+	// Shards is the number of shards in the map.
+	Shards int
+	// MinShardCount is the number of items in the least populated shard.
+	MinShardCount int64
+	// MaxShardCount is the number of items in the most populated shard.
+	MaxShardCount int64
+	// MeanShardCount is the average number of items per shard.
+	MeanShardCount float64
+	// Skew is MaxShardCount / MeanShardCount; 1 means perfectly even.
+	// It is 0 for an empty map.
+	Skew float64
+	// LoadFactor is the estimated fill ratio of the shards' underlying
+	// Go maps, averaged across shards (see estimateLoadFactor).
+	LoadFactor float64
+	// Hits and Misses count the lookups made by Get since construction
+	// or the last ResetStats. They are only tracked WithHitStats.
+	Hits   int64
+	Misses int64
+}
+
+// Stats returns a MapStats snapshot, taking each shard's read lock once.
+// Shards are read one after another, so under concurrent writes the
+// snapshot is not atomic across shards.
+func (m DMap[K, V]) Stats() MapStats {
+	stats := MapStats{Shards: len(m)}
+	if len(m) == 0 {
+		return stats
+	}
+	st := m.state()
+	stats.Hits = atomic.LoadInt64(&st.hits)
+	stats.Misses = atomic.LoadInt64(&st.misses)
+
+	var load float64
+	for i, shard := range m {
+		shard.mu.RLock()
+		n := shard.count
+		shard.mu.RUnlock()
+
+		stats.Count += n
+		if i == 0 || n < stats.MinShardCount {
+			stats.MinShardCount = n
+		}
+		if n > stats.MaxShardCount {
+			stats.MaxShardCount = n
+		}
+		load += estimateLoadFactor(n)
+	}
+
+	stats.MeanShardCount = float64(stats.Count) / float64(len(m))
+	if stats.MeanShardCount > 0 {
+		stats.Skew = float64(stats.MaxShardCount) / stats.MeanShardCount
+	}
+	stats.LoadFactor = load / float64(len(m))
+	return stats
+}
+
+// HitRatio returns the fraction of Get calls that found their key, or 0
+// if there were none. Hits and misses are only counted WithHitStats.
+func (m DMap[K, V]) HitRatio() float64 {
+	st := m.state()
+	hits := atomic.LoadInt64(&st.hits)
+	total := hits + atomic.LoadInt64(&st.misses)
+	if total == 0 {
+		return 0
+	}
+	return float64(hits) / float64(total)
+}
+
+// ResetStats zeroes the hit and miss counters.
+func (m DMap[K, V]) ResetStats() {
+	st := m.state()
+	atomic.StoreInt64(&st.hits, 0)
+	atomic.StoreInt64(&st.misses, 0)
+}
+
+// estimateLoadFactor approximates how full a Go map holding n items is.
+// It models the classic runtime layout: buckets of 8 slots, doubling
+// whenever the average exceeds 6.5 items per bucket.
+// The runtime does not expose its real layout, so this is only an estimate.
+func estimateLoadFactor(n int64) float64 {
+	if n == 0 {
+		return 0
+	}
+	buckets := int64(1)
+	for float64(n) > 6.5*float64(buckets) {
+		buckets <<= 1
+	}
+	return float64(n) / float64(8*buckets)
+}
+
+// ShardStat describes one shard of a DMap.
+type ShardStat struct {
+	// Index is the shard's index in the map.
+	Index int
+	// Count is the number of items in the shard.
+	Count int64
+	// Reads and Writes count the shard's operations since construction;
+	// they are only tracked WithShardOpStats.
+	Reads  int64
+	Writes int64
+}
+
+// ShardStats returns a ShardStat for every shard, in index order.
+func (m DMap[K, V]) ShardStats() []ShardStat {
+	stats := make([]ShardStat, len(m))
+	for i, shard := range m {
+		shard.mu.RLock()
+		n := shard.count
+		shard.mu.RUnlock()
+		stats[i] = ShardStat{
+			Index:  i,
+			Count:  n,
+			Reads:  atomic.LoadInt64(&shard.reads),
+			Writes: atomic.LoadInt64(&shard.writes),
+		}
+	}
+	return stats
+}
+
+// ShardLoadHistogram returns a histogram of the shards by entry count,
+// to see at a glance whether load is uniform or long-tailed: the counts
+// from the smallest to the largest shard are split into buckets bins of
+// equal width, and bin i holds the number of shards whose count falls
+// into the i'th of them (the last bin includes the largest count). If
+// all shards hold the same count, they are all in bin 0. It returns nil
+// if buckets < 1.
+func (m DMap[K, V]) ShardLoadHistogram(buckets int) []int {
+	if buckets < 1 {
+		return nil
+	}
+	counts := make([]int64, len(m))
+	for i, shard := range m {
+		shard.mu.RLock()
+		counts[i] = shard.count
+		shard.mu.RUnlock()
+	}
+	min, max := counts[0], counts[0]
+	for _, n := range counts {
+		if n < min {
+			min = n
+		}
+		if n > max {
+			max = n
+		}
+	}
+	hist := make([]int, buckets)
+	for _, n := range counts {
+		b := 0
+		if max > min {
+			b = int((n - min) * int64(buckets) / (max - min))
+			if b == buckets {
+				b--
+			}
+		}
+		hist[b]++
+	}
+	return hist
+}
+
+// ShardIndex returns the index of the shard key is placed on.
+func (m DMap[K, V]) ShardIndex(key K) int {
+	key = m.normalize(key)
+	return m.getShardIndex(key)
+}
+
+// KeysInShard returns the keys held by the shard at index, e.g. to
+// inspect a hot shard. It returns nil if index is out of range.
+func (m DMap[K, V]) KeysInShard(index int) []K {
+	if index < 0 || index >= len(m) {
+		return nil
+	}
+	shard := m[index]
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	keys := make([]K, 0, len(shard.items))
+	now := shard.now()
+	for key := range shard.items {
+		if !shard.expiredAt(key, now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,193 @@
+package dmap
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	m := New[string, int](10)
+
+	// Put every key that hashes to shard 0 in the map, but only
+	// every tenth key that lands elsewhere.
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("key_%d", i)
+		if m.getShardIndex(key) == 0 || i%10 == 0 {
+			m.Set(key, i)
+		}
+	}
+
+	stats := m.Stats()
+	require.Equal(t, 10, stats.Shards)
+	require.Equal(t, m.Count(), stats.Count)
+	require.Greater(t, stats.Skew, 1.0)
+	require.LessOrEqual(t, float64(stats.MinShardCount), stats.MeanShardCount)
+	require.LessOrEqual(t, stats.MeanShardCount, float64(stats.MaxShardCount))
+	require.InDelta(t, float64(stats.Count)/10, stats.MeanShardCount, 1e-9)
+	require.Greater(t, stats.LoadFactor, 0.0)
+	require.LessOrEqual(t, stats.LoadFactor, 6.5/8)
+
+	var sum int
+	for _, shard := range m {
+		sum += len(shard.items)
+	}
+	require.EqualValues(t, sum, stats.Count)
+	require.EqualValues(t, len(m[0].items), stats.MaxShardCount)
+}
+
+func TestStatsEmpty(t *testing.T) {
+	m := New[string, int](4)
+
+	stats := m.Stats()
+	require.Equal(t, MapStats{Shards: 4}, stats)
+}
+
+func TestHitRatio(t *testing.T) {
+	m := New[string, int](4, WithHitStats[string, int]())
+	require.Zero(t, m.HitRatio())
+
+	m.Set("a", 1)
+	m.Set("b", 2)
+	for i := 0; i < 30; i++ {
+		m.Get("a")
+	}
+	for i := 0; i < 10; i++ {
+		m.Get("missing")
+	}
+	m.Has("missing") // only Get is counted
+
+	require.InDelta(t, 0.75, m.HitRatio(), 1e-9)
+	stats := m.Stats()
+	require.EqualValues(t, 30, stats.Hits)
+	require.EqualValues(t, 10, stats.Misses)
+
+	m.ResetStats()
+	require.Zero(t, m.HitRatio())
+	m.Get("b")
+	require.Equal(t, 1.0, m.HitRatio())
+}
+
+func TestHitRatioDisabled(t *testing.T) {
+	m := New[string, int](4)
+	m.Set("a", 1)
+	m.Get("a")
+	m.Get("missing")
+
+	require.Zero(t, m.HitRatio())
+}
+
+func TestKeysInShard(t *testing.T) {
+	m := New[string, string](10)
+	prepareTestData(m, 1000, "some val")
+
+	i := m.ShardIndex(keys[0])
+	require.Contains(t, m.KeysInShard(i), keys[0])
+
+	var all []string
+	for i := 0; i < len(m); i++ {
+		for _, k := range m.KeysInShard(i) {
+			require.Equal(t, i, m.ShardIndex(k))
+			all = append(all, k)
+		}
+	}
+	require.ElementsMatch(t, keys, all)
+
+	require.Nil(t, m.KeysInShard(-1))
+	require.Nil(t, m.KeysInShard(len(m)))
+}
+
+func TestShardStats(t *testing.T) {
+	m := New[string, int](4, WithShardOpStats[string, int]())
+	a, b := "a", "b"
+	for m.getShardIndex(b) == m.getShardIndex(a) {
+		b += "b"
+	}
+	ia, ib := m.getShardIndex(a), m.getShardIndex(b)
+
+	for i := 0; i < 3; i++ {
+		m.Set(a, i)
+	}
+	m.Set(b, 0)
+	for i := 0; i < 10; i++ {
+		m.Get(a)
+	}
+	m.Has(b)
+	m.Has(b)
+	m.Remove(b)
+
+	stats := m.ShardStats()
+	require.Len(t, stats, 4)
+	for i, s := range stats {
+		require.Equal(t, i, s.Index)
+		switch i {
+		case ia:
+			require.Equal(t, ShardStat{Index: i, Count: 1, Reads: 10, Writes: 3}, s)
+		case ib:
+			require.Equal(t, ShardStat{Index: i, Count: 0, Reads: 2, Writes: 2}, s)
+		default:
+			require.Equal(t, ShardStat{Index: i}, s)
+		}
+	}
+}
+
+func TestShardStatsWithoutOpStats(t *testing.T) {
+	m := New[string, int](4)
+	m.Set("a", 1)
+	m.Get("a")
+
+	i := m.getShardIndex("a")
+	require.Equal(t, ShardStat{Index: i, Count: 1}, m.ShardStats()[i])
+}
+
+// TestCountTypes guards the count path against int truncation on 32-bit
+// platforms: every count is an int64.
+func TestCountTypes(t *testing.T) {
+	int64Type := reflect.TypeOf(int64(0))
+	field, ok := reflect.TypeOf(Shard[string, int]{}).FieldByName("count")
+	require.True(t, ok)
+	require.Equal(t, int64Type, field.Type)
+
+	m := New[string, int](2)
+	require.Equal(t, int64Type, reflect.TypeOf(m.Count()))
+	for _, name := range []string{"Count", "MinShardCount", "MaxShardCount"} {
+		f, _ := reflect.TypeOf(MapStats{}).FieldByName(name)
+		require.Equal(t, int64Type, f.Type, name)
+	}
+	f, _ := reflect.TypeOf(ShardStat{}).FieldByName("Count")
+	require.Equal(t, int64Type, f.Type)
+
+	// Counts well past math.MaxInt32 add up without wrapping.
+	m[0].count, m[1].count = math.MaxInt32, math.MaxInt32
+	require.EqualValues(t, 2*int64(math.MaxInt32), m.Count())
+	require.EqualValues(t, 2*int64(math.MaxInt32), m.Stats().Count)
+	m[0].count, m[1].count = 0, 0
+}
+
+func TestShardLoadHistogram(t *testing.T) {
+	// Shard i holds the keys k with k%16 == i: two hot shards of 1000
+	// entries, the rest between 10 and 23.
+	m := New[int, int](16, WithHasher[int, int](func(k int) uint64 { return uint64(k % 16) }))
+	for i := 0; i < 16; i++ {
+		n := 10 + i
+		if i == 3 || i == 11 {
+			n = 1000
+		}
+		for j := 0; j < n; j++ {
+			m.Set(j*16+i, j)
+		}
+	}
+	require.Equal(t, []int{14, 0, 0, 2}, m.ShardLoadHistogram(4))
+	require.Equal(t, []int{16}, m.ShardLoadHistogram(1))
+	hist := m.ShardLoadHistogram(100)
+	require.Len(t, hist, 100)
+	require.Equal(t, 14, hist[0]+hist[1])
+	require.Equal(t, 2, hist[99])
+
+	// Uniform load lands in the first bin.
+	require.Equal(t, []int{4, 0, 0}, New[int, int](4).ShardLoadHistogram(3))
+	require.Nil(t, m.ShardLoadHistogram(0))
+}
@@ -0,0 +1,62 @@
+package dmap
+
+import "time"
+
+// RemoveWithTombstone removes key like Remove, but leaves a tombstone in
+// its place for ttl, so the delete can be propagated to replicas: it is
+// published as an EventTombstone (see Subscribe) and reported by
+// IsTombstoned and Tombstones until it expires. Get and Has treat a
+// tombstoned key as absent, and a later write of key clears the
+// tombstone. Expired tombstones are ignored, and dropped from memory
+// by WithJanitor or the next write of their key.
+func (m DMap[K, V]) RemoveWithTombstone(key K, ttl time.Duration) {
+	key = m.normalize(key)
+	shard := m.getShard(key)
+	if !shard.lockWrite() {
+		return
+	}
+	defer shard.mu.Unlock()
+	old, _ := shard.remove(key)
+	if shard.tombstones == nil {
+		shard.tombstones = make(map[K]time.Time)
+	}
+	shard.tombstones[key] = shard.now().Add(ttl)
+	shard.publish(EventTombstone, key, old)
+}
+
+// IsTombstoned reports whether key was removed with RemoveWithTombstone
+// and its tombstone has not expired.
+func (m DMap[K, V]) IsTombstoned(key K) bool {
+	key = m.normalize(key)
+	shard := m.getShard(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	expireAt, ok := shard.tombstones[key]
+	return ok && shard.now().Before(expireAt)
+}
+
+// Tombstones returns the keys with unexpired tombstones.
+func (m DMap[K, V]) Tombstones() []K {
+	keys := make([]K, 0)
+	for _, shard := range m {
+		shard.mu.RLock()
+		now := shard.now()
+		for k, expireAt := range shard.tombstones {
+			if now.Before(expireAt) {
+				keys = append(keys, k)
+			}
+		}
+		shard.mu.RUnlock()
+	}
+	return keys
+}
+
+// reapTombstones drops the tombstones that have expired as of now.
+// The caller must hold the shard's write lock.
+func (s *Shard[K, V]) reapTombstones(now time.Time) {
+	for k, expireAt := range s.tombstones {
+		if !now.Before(expireAt) {
+			delete(s.tombstones, k)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package dmap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveWithTombstone(t *testing.T) {
+	m := New[string, int](4)
+	m.Set("a", 1)
+	events, cancel := m.Subscribe(10)
+	defer cancel()
+
+	m.RemoveWithTombstone("a", 20*time.Millisecond)
+	_, ok := m.Get("a")
+	require.False(t, ok)
+	require.False(t, m.Has("a"))
+	require.EqualValues(t, 0, m.Count())
+	require.True(t, m.IsTombstoned("a"))
+	require.Equal(t, []string{"a"}, m.Tombstones())
+	require.Equal(t, Event[string, int]{EventTombstone, "a", 1}, <-events)
+	require.Empty(t, events)
+
+	time.Sleep(30 * time.Millisecond)
+	require.False(t, m.IsTombstoned("a"))
+	require.Empty(t, m.Tombstones())
+}
+
+func TestTombstoneClearedByWrite(t *testing.T) {
+	m := New[string, int](4)
+	m.RemoveWithTombstone("a", time.Hour)
+	require.True(t, m.IsTombstoned("a"))
+	m.Set("a", 2)
+	require.False(t, m.IsTombstoned("a"))
+	v, ok := m.Get("a")
+	require.True(t, ok)
+	require.Equal(t, 2, v)
+}
+
+func TestTombstoneReapedByJanitor(t *testing.T) {
+	m := New[string, int](4, WithJanitor[string, int](time.Millisecond))
+	defer m.Close()
+	m.Set("a", 1)
+	m.RemoveWithTombstone("a", 5*time.Millisecond)
+	shard := m.getShard("a")
+	require.Eventually(t, func() bool {
+		shard.mu.RLock()
+		defer shard.mu.RUnlock()
+		return len(shard.tombstones) == 0
+	}, time.Second, time.Millisecond)
+}
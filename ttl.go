@@ -0,0 +1,222 @@
+package dmap
+
+import "time"
+
+// SetWithTTL sets the given key, value in the map, expiring it after ttl.
+// A ttl <= 0 means the entry never expires, same as Set. Conversely, a
+// later Set (or Compute) of key stores it without expiry.
+//
+// Expired entries are treated as absent by reads, but stay in memory,
+// and in Count, until key is written or removed.
+func (m DMap[K, V]) SetWithTTL(key K, val V, ttl time.Duration) {
+	key = m.normalize(key)
+	shard := m.getShard(key)
+	if shard.validate(key, val) != nil {
+		return
+	}
+	var added bool
+	shard.locked(func() {
+		var stored bool
+		if stored, added = shard.set(key, val); stored {
+			shard.setExpiry(key, ttl)
+		}
+	})
+	if added {
+		m.evictOverflow(key)
+	}
+}
+
+// SetTTL makes the entry for key expire after ttl, or never if ttl <= 0,
+// without changing its value. It reports whether key was present; an
+// absent (or already expired) key is left alone.
+func (m DMap[K, V]) SetTTL(key K, ttl time.Duration) bool {
+	key = m.normalize(key)
+	shard := m.getShard(key)
+	if !shard.lockWrite() {
+		return false
+	}
+	defer shard.mu.Unlock()
+	if !shard.contains(key) {
+		return false
+	}
+	shard.setExpiry(key, ttl)
+	return true
+}
+
+// Persist removes the expiry of the entry for key, so it never expires,
+// and reports whether key was present. It is SetTTL with a ttl of 0.
+func (m DMap[K, V]) Persist(key K) bool {
+	key = m.normalize(key)
+	return m.SetTTL(key, 0)
+}
+
+// GetAndExtendIfPresent is like Get, but if key is present and expires in
+// less than minRemaining, it also makes key expire after newTTL, e.g. to
+// keep entries that will be needed soon from expiring while warming a
+// cache. Entries further from expiry, or without TTL, are left as is, as
+// are all entries of a frozen or closed map.
+func (m DMap[K, V]) GetAndExtendIfPresent(key K, minRemaining, newTTL time.Duration) (V, bool) {
+	key = m.normalize(key)
+	shard := m.getShard(key)
+	shard.mu.RLock()
+	v, ok := shard.lookup(key)
+	// A frozen map stays as is; the read still succeeds.
+	extend := ok && shard.expiresWithin(key, minRemaining) && !shard.state.isFrozen()
+	// The use is recorded under the shard lock, which keeps it from
+	// racing with track.
+	if ok && !extend && shard.cfg.sizeOf != nil && !shard.state.isFrozen() {
+		shard.touch(key)
+	}
+	shard.mu.RUnlock()
+	if extend {
+		shard.locked(func() {
+			// Re-check: key may have been written or removed meanwhile.
+			if v, ok = shard.lookup(key); !ok {
+				return
+			}
+			if shard.expiresWithin(key, minRemaining) {
+				shard.setExpiry(key, newTTL)
+			}
+			if shard.cfg.sizeOf != nil {
+				shard.touch(key)
+			}
+		})
+	}
+	if shard.cfg.hitStats {
+		shard.state.recordLookup(ok)
+	}
+	if ok {
+		v = shard.readCopy(v)
+	}
+	return v, ok
+}
+
+// expiresWithin reports whether key has a TTL that runs out in less
+// than d. The caller must hold the shard's lock.
+func (s *Shard[K, V]) expiresWithin(key K, d time.Duration) bool {
+	expireAt, ok := s.expires[key]
+	return ok && expireAt.Sub(s.now()) < d
+}
+
+// GetAllowStale is like Get, but also returns expired entries, flagged
+// with fresh == false, e.g. to serve a stale value while it is reloaded.
+// Expired entries remain available until they are written or removed,
+// or WithMaxStale has passed since they expired.
+func (m DMap[K, V]) GetAllowStale(key K) (val V, fresh, present bool) {
+	key = m.normalize(key)
+	shard := m.getShard(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	v, ok := shard.items[key]
+	if !ok {
+		return val, false, false
+	}
+	expireAt, expires := shard.expires[key]
+	if !expires {
+		return shard.readCopy(v), true, true
+	}
+	now := shard.now()
+	if now.Before(expireAt) {
+		return shard.readCopy(v), true, true
+	}
+	if max := shard.cfg.maxStale; max > 0 && !now.Before(expireAt.Add(max)) {
+		return val, false, false
+	}
+	return shard.readCopy(v), false, true
+}
+
+// setExpiry makes key expire ttl from now, or never if ttl <= 0.
+// The caller must hold the shard's write lock.
+func (s *Shard[K, V]) setExpiry(key K, ttl time.Duration) {
+	if ttl <= 0 {
+		delete(s.expires, key)
+		return
+	}
+	s.setExpireAt(key, s.now().Add(ttl))
+}
+
+// setExpireAt makes key expire at expireAt.
+// The caller must hold the shard's write lock.
+func (s *Shard[K, V]) setExpireAt(key K, expireAt time.Time) {
+	if s.expires == nil {
+		s.expires = make(map[K]time.Time)
+	}
+	s.expires[key] = expireAt
+}
+
+// CountLive returns the number of entries in the map that have not
+// expired. Count includes expired entries until they are removed (see
+// DeleteExpired and WithJanitor), but is O(shards); CountLive checks the
+// expiry of every entry that has a TTL, so it is O(n) in those entries.
+// Shards are counted one at a time under their read lock.
+func (m DMap[K, V]) CountLive() int64 {
+	var count int64
+	for _, shard := range m {
+		shard.mu.RLock()
+		count += shard.count
+		if len(shard.expires) > 0 {
+			now := shard.now()
+			for key := range shard.expires {
+				if shard.expiredAt(key, now) {
+					count--
+				}
+			}
+		}
+		shard.mu.RUnlock()
+	}
+	return count
+}
+
+// DeleteExpired removes the expired entries from the map and returns how
+// many it removed. It is the manual counterpart to WithJanitor, for maps
+// that reclaim the memory of expired entries on their own schedule;
+// like the janitor, it keeps entries that are still within WithMaxStale
+// and also drops expired tombstones. Shards are swept one at a time.
+func (m DMap[K, V]) DeleteExpired() int {
+	removed := 0
+	for _, shard := range m {
+		if !shard.locked(func() { removed += shard.reapExpired() }) {
+			return removed
+		}
+	}
+	return removed
+}
+
+// reapExpired removes the expired entries and tombstones from the shard,
+// and returns the number of entries removed.
+// The caller must hold the shard's write lock.
+func (s *Shard[K, V]) reapExpired() int {
+	now := s.now()
+	// Stale entries stay for GetAllowStale until WithMaxStale.
+	reapAt := now.Add(-s.cfg.maxStale)
+	removed := 0
+	for k := range s.expires {
+		if s.expiredAt(k, reapAt) {
+			s.delete(k)
+			removed++
+		}
+	}
+	s.reapTombstones(now)
+	return removed
+}
+
+// expired reports whether key has expired.
+// The caller must hold the shard's lock.
+func (s *Shard[K, V]) expired(key K) bool {
+	if len(s.expires) == 0 {
+		return false
+	}
+	return s.expiredAt(key, s.now())
+}
+
+// expiredAt reports whether key has expired as of now.
+// The caller must hold the shard's lock.
+func (s *Shard[K, V]) expiredAt(key K, now time.Time) bool {
+	expireAt, ok := s.expires[key]
+	return ok && !now.Before(expireAt)
+}
+
+// now returns the current time by the map's clock (see WithClock).
+func (s *Shard[K, V]) now() time.Time {
+	return s.cfg.clock.Now()
+}
@@ -0,0 +1,307 @@
+package dmap
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetWithTTL(t *testing.T) {
+	m := New[string, int](4)
+	m.SetWithTTL("short", 1, 30*time.Millisecond)
+	m.SetWithTTL("forever", 2, 0)
+	m.Set("plain", 3)
+
+	v, ok := m.Get("short")
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+	require.ElementsMatch(t, []string{"short", "forever", "plain"}, m.Keys())
+
+	time.Sleep(40 * time.Millisecond)
+	_, ok = m.Get("short")
+	require.False(t, ok)
+	require.False(t, m.Has("short"))
+	require.ElementsMatch(t, []string{"forever", "plain"}, m.Keys())
+	require.True(t, m.Has("forever"))
+
+	// Writing an expired key revives it without double counting.
+	require.EqualValues(t, 3, m.Count())
+	m.Set("short", 4)
+	v, ok = m.Get("short")
+	require.True(t, ok)
+	require.Equal(t, 4, v)
+	require.EqualValues(t, 3, m.Count())
+}
+
+func TestSetClearsTTL(t *testing.T) {
+	m := New[string, int](4)
+	m.SetWithTTL("a", 1, 30*time.Millisecond)
+	m.Set("a", 2)
+
+	time.Sleep(40 * time.Millisecond)
+	v, ok := m.Get("a")
+	require.True(t, ok)
+	require.Equal(t, 2, v)
+}
+
+func TestComputeSeesExpiredAsAbsent(t *testing.T) {
+	m := New[string, int](4)
+	m.SetWithTTL("a", 10, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	got := m.Compute("a", func(old int, exists bool) int {
+		require.False(t, exists)
+		require.Zero(t, old)
+		return 1
+	})
+	require.Equal(t, 1, got)
+	require.EqualValues(t, 1, m.Count())
+}
+
+// fakeClock is a Clock that only moves when advanced.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestWithClock(t *testing.T) {
+	clock := newFakeClock()
+	m := New[string, int](4, WithClock[string, int](clock))
+	m.SetWithTTL("k", 1, time.Minute)
+	m.RemoveWithTombstone("gone", time.Minute)
+
+	clock.Advance(time.Minute - time.Nanosecond)
+	require.True(t, m.Has("k"))
+	require.True(t, m.IsTombstoned("gone"))
+
+	clock.Advance(time.Nanosecond)
+	require.False(t, m.Has("k"))
+	require.False(t, m.IsTombstoned("gone"))
+	require.Empty(t, m.Keys())
+
+	// Clones keep the clock.
+	c := m.Clone()
+	c.SetWithTTL("k", 1, time.Second)
+	clock.Advance(time.Second)
+	require.False(t, c.Has("k"))
+
+	w := New[string, Window](4, WithClock[string, Window](clock))
+	require.EqualValues(t, 1, IncrementWindow(w, "client", time.Minute))
+	require.EqualValues(t, 2, IncrementWindow(w, "client", time.Minute))
+	clock.Advance(time.Minute)
+	require.EqualValues(t, 1, IncrementWindow(w, "client", time.Minute))
+}
+
+func TestSetTTL(t *testing.T) {
+	clock := newFakeClock()
+	m := New[string, int](4, WithClock[string, int](clock))
+	m.Set("k", 1)
+	require.True(t, m.SetTTL("k", time.Minute))
+	require.False(t, m.SetTTL("missing", time.Hour))
+	require.False(t, m.Has("missing"))
+
+	v, ok := m.Get("k")
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+
+	clock.Advance(time.Minute)
+	require.False(t, m.Has("k"))
+	require.False(t, m.SetTTL("k", time.Hour), "expired keys are absent")
+
+	// Extending an existing expiry.
+	m.SetWithTTL("ext", 2, time.Minute)
+	require.True(t, m.SetTTL("ext", time.Hour))
+	clock.Advance(time.Minute)
+	require.True(t, m.Has("ext"))
+}
+
+func TestPersist(t *testing.T) {
+	clock := newFakeClock()
+	m := New[string, int](4, WithClock[string, int](clock))
+	m.SetWithTTL("hot", 1, time.Minute)
+	require.True(t, m.Persist("hot"))
+	require.False(t, m.Persist("missing"))
+
+	clock.Advance(time.Hour)
+	v, ok := m.Get("hot")
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+}
+
+func TestGetAllowStale(t *testing.T) {
+	clock := newFakeClock()
+	m := New[string, int](4, WithClock[string, int](clock))
+	m.SetWithTTL("soft", 1, time.Minute)
+	m.Set("plain", 2)
+
+	v, fresh, present := m.GetAllowStale("soft")
+	require.Equal(t, 1, v)
+	require.True(t, fresh)
+	require.True(t, present)
+
+	clock.Advance(time.Hour)
+	_, ok := m.Get("soft")
+	require.False(t, ok)
+	v, fresh, present = m.GetAllowStale("soft")
+	require.Equal(t, 1, v)
+	require.False(t, fresh)
+	require.True(t, present)
+
+	v, fresh, present = m.GetAllowStale("plain")
+	require.Equal(t, 2, v)
+	require.True(t, fresh)
+	require.True(t, present)
+
+	_, fresh, present = m.GetAllowStale("missing")
+	require.False(t, fresh)
+	require.False(t, present)
+}
+
+func TestGetAllowStaleMaxStale(t *testing.T) {
+	clock := newFakeClock()
+	m := New[string, int](4,
+		WithClock[string, int](clock),
+		WithMaxStale[string, int](time.Minute))
+	m.SetWithTTL("k", 1, time.Second)
+
+	clock.Advance(time.Second + time.Minute - time.Nanosecond)
+	_, fresh, present := m.GetAllowStale("k")
+	require.False(t, fresh)
+	require.True(t, present)
+
+	clock.Advance(time.Nanosecond)
+	_, _, present = m.GetAllowStale("k")
+	require.False(t, present)
+}
+
+func TestDeleteExpired(t *testing.T) {
+	clock := newFakeClock()
+	m := New[string, int](4, WithClock[string, int](clock))
+	for i := 0; i < 100; i++ {
+		m.SetWithTTL(fmt.Sprintf("short_%d", i), i, time.Second)
+		m.SetWithTTL(fmt.Sprintf("long_%d", i), i, time.Hour)
+		m.Set(fmt.Sprintf("plain_%d", i), i)
+	}
+	require.Zero(t, m.DeleteExpired())
+	require.EqualValues(t, 300, m.Count())
+
+	clock.Advance(time.Second)
+	require.Equal(t, 100, m.DeleteExpired())
+	require.EqualValues(t, 200, m.Count())
+	require.Zero(t, m.DeleteExpired())
+
+	clock.Advance(time.Hour)
+	require.Equal(t, 100, m.DeleteExpired())
+	require.EqualValues(t, 100, m.Count())
+}
+
+func TestGetAndExtendIfPresent(t *testing.T) {
+	clock := newFakeClock()
+	m := New[string, int](4, WithClock[string, int](clock))
+	m.SetWithTTL("near", 1, 10*time.Second)
+	m.SetWithTTL("fresh", 2, time.Hour)
+	m.Set("plain", 3)
+
+	v, ok := m.GetAndExtendIfPresent("near", time.Minute, 2*time.Hour)
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+	v, ok = m.GetAndExtendIfPresent("fresh", time.Minute, 3*time.Hour)
+	require.True(t, ok)
+	require.Equal(t, 2, v)
+	v, ok = m.GetAndExtendIfPresent("plain", time.Minute, time.Hour)
+	require.True(t, ok)
+	require.Equal(t, 3, v)
+	_, ok = m.GetAndExtendIfPresent("missing", time.Minute, time.Hour)
+	require.False(t, ok)
+	require.False(t, m.Has("missing"))
+
+	// "near" was extended to two hours, "fresh" kept its hour, "plain"
+	// still never expires.
+	clock.Advance(time.Hour)
+	require.True(t, m.Has("near"))
+	require.False(t, m.Has("fresh"))
+	clock.Advance(time.Hour)
+	require.False(t, m.Has("near"))
+	require.True(t, m.Has("plain"))
+}
+
+func TestGetAndExtendIfPresentFrozen(t *testing.T) {
+	clock := newFakeClock()
+	m := New[string, int](4, WithClock[string, int](clock))
+	m.SetWithTTL("a", 1, time.Minute)
+	m.Freeze()
+
+	v, ok := m.GetAndExtendIfPresent("a", time.Hour, 2*time.Hour)
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+	clock.Advance(time.Minute)
+	require.False(t, m.Has("a"), "not extended")
+}
+
+func TestGetAndExtendIfPresentTouchesUnderLock(t *testing.T) {
+	m := New[int, int](1, WithMemoryBudget[int, int](1<<20, func(int) int64 { return 1 }))
+	for i := 0; i < 100; i++ {
+		m.SetWithTTL(i, i, time.Hour)
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			m.GetAndExtendIfPresent(i%100, time.Minute, time.Hour)
+			m.GetAndExtendIfPresent(i%100, 2*time.Hour, time.Hour)
+			runtime.Gosched()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			m.Set(100+i, i)
+			runtime.Gosched()
+		}
+	}()
+	wg.Wait()
+	require.EqualValues(t, 1100, m.MemoryUsage())
+}
+
+func TestCountLive(t *testing.T) {
+	clock := newFakeClock()
+	m := New[string, int](4, WithClock[string, int](clock))
+	for i := 0; i < 100; i++ {
+		m.SetWithTTL(fmt.Sprintf("short_%d", i), i, time.Second)
+		m.SetWithTTL(fmt.Sprintf("long_%d", i), i, time.Hour)
+		m.Set(fmt.Sprintf("plain_%d", i), i)
+	}
+	require.EqualValues(t, 300, m.CountLive())
+
+	clock.Advance(time.Second)
+	require.EqualValues(t, 300, m.Count(), "Count includes expired entries")
+	require.EqualValues(t, 200, m.CountLive())
+	require.Len(t, m.Keys(), 200)
+
+	clock.Advance(time.Hour)
+	require.EqualValues(t, 100, m.CountLive())
+	m.DeleteExpired()
+	require.EqualValues(t, 100, m.Count())
+	require.EqualValues(t, 100, m.CountLive())
+}
@@ -0,0 +1,70 @@
+package dmap
+
+// Every write to a key of a map built WithVersioning gives that entry a
+// new version. Versions come from a per-shard sequence, so they strictly
+// increase and are never reused for a key, even after it is removed and
+// set again. Absent keys have version 0.
+
+// GetVersioned returns the value and version of key.
+// If key is not found, ok is false and the version is 0.
+func (m DMap[K, V]) GetVersioned(key K) (val V, version uint64, ok bool) {
+	key = m.normalize(key)
+	shard := m.getShard(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	val, ok = shard.lookup(key)
+	if !ok {
+		return val, 0, false
+	}
+	return shard.readCopy(val), shard.versions[key], true
+}
+
+// SetIfVersion sets key to val only if the key's current version is
+// expectedVersion (0 to require that key is absent), returning the
+// entry's version afterwards and whether the write happened.
+// It panics with ErrNotVersioned unless the map is built WithVersioning.
+func (m DMap[K, V]) SetIfVersion(key K, val V, expectedVersion uint64) (uint64, bool) {
+	key = m.normalize(key)
+	if !m.config().versioning {
+		panic(ErrNotVersioned)
+	}
+	shard := m.getShard(key)
+	if shard.validate(key, val) != nil {
+		shard.mu.RLock()
+		defer shard.mu.RUnlock()
+		return shard.version(key), false
+	}
+	var current uint64
+	var stored, added bool
+	shard.locked(func() {
+		if current = shard.version(key); current != expectedVersion {
+			return
+		}
+		if stored, added = shard.set(key, val); stored {
+			current = shard.versions[key]
+		}
+	})
+	if added {
+		m.evictOverflow(key)
+	}
+	return current, stored
+}
+
+// version returns the version of key, 0 if it is absent.
+// The caller must hold the shard's lock.
+func (s *Shard[K, V]) version(key K) uint64 {
+	if !s.contains(key) {
+		return 0
+	}
+	return s.versions[key]
+}
+
+// bumpVersion gives key the next version of the shard.
+// The caller must hold the shard's write lock.
+func (s *Shard[K, V]) bumpVersion(key K) {
+	if s.versions == nil {
+		s.versions = make(map[K]uint64)
+	}
+	s.seq++
+	s.versions[key] = s.seq
+}
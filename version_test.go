@@ -0,0 +1,55 @@
+package dmap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetIfVersion(t *testing.T) {
+	m := New[string, int](4, WithVersioning[string, int]())
+
+	_, v0, ok := m.GetVersioned("a")
+	require.False(t, ok)
+	require.Zero(t, v0)
+
+	v1, ok := m.SetIfVersion("a", 1, 0)
+	require.True(t, ok)
+	require.NotZero(t, v1)
+
+	// Two writers read the same version...
+	val, seen, ok := m.GetVersioned("a")
+	require.True(t, ok)
+	require.Equal(t, 1, val)
+	require.Equal(t, v1, seen)
+
+	// ...the first one wins...
+	v2, ok := m.SetIfVersion("a", 2, seen)
+	require.True(t, ok)
+	require.Greater(t, v2, v1)
+
+	// ...and the stale one is rejected with the current version.
+	cur, ok := m.SetIfVersion("a", 3, seen)
+	require.False(t, ok)
+	require.Equal(t, v2, cur)
+	val, _, _ = m.GetVersioned("a")
+	require.Equal(t, 2, val)
+
+	// Plain writes bump the version too.
+	m.Set("a", 4)
+	_, v3, _ := m.GetVersioned("a")
+	require.Greater(t, v3, v2)
+
+	// Versions are not reused after a remove.
+	m.Remove("a")
+	_, ok = m.SetIfVersion("a", 5, v3)
+	require.False(t, ok)
+	v4, ok := m.SetIfVersion("a", 5, 0)
+	require.True(t, ok)
+	require.Greater(t, v4, v3)
+}
+
+func TestSetIfVersionRequiresVersioning(t *testing.T) {
+	m := New[string, int](4)
+	require.PanicsWithValue(t, ErrNotVersioned, func() { m.SetIfVersion("a", 1, 0) })
+}
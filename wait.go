@@ -0,0 +1,89 @@
+package dmap
+
+import (
+	"context"
+	"time"
+)
+
+// waitPollInterval is how often WaitForCount re-checks Count.
+const waitPollInterval = 5 * time.Millisecond
+
+// WaitForCount blocks until the map holds at least target items or ctx
+// is done, in which case it returns ctx.Err().
+// It is meant mostly for synchronizing tests with concurrent producers.
+func (m DMap[K, V]) WaitForCount(ctx context.Context, target int64) error {
+	ticker := time.NewTicker(waitPollInterval)
+	defer ticker.Stop()
+	for {
+		if m.Count() >= target {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// WaitFor blocks until key is present in the map and returns its value,
+// or returns ctx.Err() if ctx is done first. If key is already present
+// WaitFor returns immediately.
+func (m DMap[K, V]) WaitFor(ctx context.Context, key K) (V, error) {
+	key = m.normalize(key)
+	shard := m.getShard(key)
+	// Checking for key and registering the waiter happen under the same
+	// write lock that writers hold, so no Set can slip in between.
+	shard.mu.Lock()
+	if v, ok := shard.lookup(key); ok {
+		shard.mu.Unlock()
+		return shard.readCopy(v), nil
+	}
+	ch := make(chan V, 1)
+	if shard.waiters == nil {
+		shard.waiters = make(map[K][]chan V)
+	}
+	shard.waiters[key] = append(shard.waiters[key], ch)
+	shard.mu.Unlock()
+
+	select {
+	case v := <-ch:
+		return shard.readCopy(v), nil
+	case <-ctx.Done():
+	}
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.unregisterWaiter(key, ch)
+	select {
+	case v := <-ch: // woken while giving up
+		return shard.readCopy(v), nil
+	default:
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+// wake hands val to every WaitFor call blocked on key.
+// The caller must hold the shard's write lock.
+func (s *Shard[K, V]) wake(key K, val V) {
+	for _, ch := range s.waiters[key] {
+		ch <- val // buffered, and each waiter is woken only once
+	}
+	delete(s.waiters, key)
+}
+
+func (s *Shard[K, V]) unregisterWaiter(key K, ch chan V) {
+	chans := s.waiters[key]
+	for i, c := range chans {
+		if c == ch {
+			chans = append(chans[:i], chans[i+1:]...)
+			break
+		}
+	}
+	if len(chans) == 0 {
+		delete(s.waiters, key)
+	} else {
+		s.waiters[key] = chans
+	}
+}
@@ -0,0 +1,90 @@
+package dmap
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitForCount(t *testing.T) {
+	m := New[string, int](10)
+	for p := 0; p < 4; p++ {
+		go func(p int) {
+			for i := 0; i < 250; i++ {
+				m.Set(fmt.Sprintf("key_%d_%d", p, i), i)
+			}
+		}(p)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, m.WaitForCount(ctx, 1000))
+	require.EqualValues(t, 1000, m.Count())
+}
+
+func TestWaitForCountTimeout(t *testing.T) {
+	m := New[string, int](10)
+	m.Set("foo", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := m.WaitForCount(ctx, 2)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestWaitFor(t *testing.T) {
+	m := New[string, int](4)
+
+	type result struct {
+		v   int
+		err error
+	}
+	results := make(chan result, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			v, err := m.WaitFor(context.Background(), "ready")
+			results <- result{v, err}
+		}()
+	}
+
+	// Give the waiters a chance to block before the key appears.
+	time.Sleep(10 * time.Millisecond)
+	m.Set("other", 1)
+	m.Set("ready", 42)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case r := <-results:
+			require.NoError(t, r.err)
+			require.Equal(t, 42, r.v)
+		case <-time.After(5 * time.Second):
+			t.Fatal("waiter was not woken")
+		}
+	}
+	require.Empty(t, m[m.getShardIndex("ready")].waiters)
+}
+
+func TestWaitForPresent(t *testing.T) {
+	m := New[string, int](4)
+	m.Set("ready", 1)
+
+	v, err := m.WaitFor(context.Background(), "ready")
+	require.NoError(t, err)
+	require.Equal(t, 1, v)
+}
+
+func TestWaitForContextDone(t *testing.T) {
+	m := New[string, int](4)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := m.WaitFor(ctx, "never")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Empty(t, m[m.getShardIndex("never")].waiters)
+
+	// A later Set must not block on the abandoned waiter.
+	m.Set("never", 1)
+}
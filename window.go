@@ -0,0 +1,28 @@
+package dmap
+
+import "time"
+
+// Window is a fixed-window counter, as stored by IncrementWindow.
+type Window struct {
+	// Start is when the current window began.
+	Start time.Time
+	// Count is the number of increments within the current window.
+	Count int64
+}
+
+// IncrementWindow increments the counter for key in its current time
+// window and returns the count within that window, which makes the map
+// usable as a sharded fixed-window rate limiter.
+// A key's first window starts at its first increment; once window has
+// elapsed since then, the next increment starts a new window at 1.
+func IncrementWindow[K comparable](m DMap[K, Window], key K, window time.Duration) int64 {
+	now := m.config().clock.Now()
+	w := m.Compute(key, func(w Window, exists bool) Window {
+		if !exists || now.Sub(w.Start) >= window {
+			return Window{Start: now, Count: 1}
+		}
+		w.Count++
+		return w
+	})
+	return w.Count
+}
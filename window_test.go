@@ -0,0 +1,22 @@
+package dmap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIncrementWindow(t *testing.T) {
+	m := New[string, Window](4)
+	window := 50 * time.Millisecond
+
+	require.EqualValues(t, 1, IncrementWindow(m, "client", window))
+	require.EqualValues(t, 2, IncrementWindow(m, "client", window))
+	require.EqualValues(t, 3, IncrementWindow(m, "client", window))
+	require.EqualValues(t, 1, IncrementWindow(m, "other", window))
+
+	time.Sleep(60 * time.Millisecond)
+	require.EqualValues(t, 1, IncrementWindow(m, "client", window))
+	require.EqualValues(t, 2, IncrementWindow(m, "client", window))
+}